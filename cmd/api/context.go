@@ -15,10 +15,41 @@ type contextKey string
 // in the request context.
 const userContextKey = contextKey("user")
 
+// tokenContextKey holds the plaintext authentication token that the request was
+// authenticated with.
+const tokenContextKey = contextKey("token")
+
+// facultyScopeContextKey holds the faculty ID that the current request is limited to
+// when the user only has an ":own_faculty" scoped permission.
+const facultyScopeContextKey = contextKey("facultyScope")
+
+// studentContextKey holds the student that the requireStudentInScope middleware looked
+// up for the /v1/students/:id routes.
+const studentContextKey = contextKey("student")
+
+// requestIDContextKey holds the ID which identifies the current request in responses
+// and log entries.
+const requestIDContextKey = contextKey("requestID")
+
+// requestInfoContextKey holds a *requestInfo, which is filled in while the request is
+// being handled and read by the logRequest and recordMetrics middleware afterwards.
+const requestInfoContextKey = contextKey("requestInfo")
+
+// requestInfo collects details about a request which are only known further down the
+// middleware chain than the access log. Handlers get a new copy of the request from
+// every middleware, so this has to be a pointer shared by all of them.
+type requestInfo struct {
+	userID int64
+	route  string // The pattern of the matched route, set by the routeTable.
+}
+
 // The contextSetUser() method returns a new copy of the request with the provided
 // User struct added to the context. Note that we use our userContextKey constant as the
 // key.
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok && !user.IsAnonymous() {
+		info.userID = user.ID
+	}
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 	return r.WithContext(ctx)
 }
@@ -34,3 +65,77 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+// The contextSetFacultyScope() method returns a new copy of the request which is
+// limited to the students of the given faculty.
+func (app *application) contextSetFacultyScope(r *http.Request, facultyID int64) *http.Request {
+	ctx := context.WithValue(r.Context(), facultyScopeContextKey, facultyID)
+	return r.WithContext(ctx)
+}
+
+// The contextGetFacultyScope() method returns the faculty ID that the request is
+// limited to. The boolean is false if the request isn't limited to any faculty.
+func (app *application) contextGetFacultyScope(r *http.Request) (int64, bool) {
+	facultyID, ok := r.Context().Value(facultyScopeContextKey).(int64)
+	return facultyID, ok
+}
+
+// The facultyInScope() helper reports whether a student in the given faculty may be
+// accessed by the current request.
+func (app *application) facultyInScope(r *http.Request, facultyID int32) bool {
+	scope, ok := app.contextGetFacultyScope(r)
+	return !ok || scope == int64(facultyID)
+}
+
+// The contextSetStudent() method returns a new copy of the request with the provided
+// Student struct added to the context.
+func (app *application) contextSetStudent(r *http.Request, student *data.Student) *http.Request {
+	ctx := context.WithValue(r.Context(), studentContextKey, student)
+	return r.WithContext(ctx)
+}
+
+// The contextGetStudent() method retrieves the Student struct from the request context.
+// Like contextGetUser(), it panics if there isn't one, as the handlers which use it are
+// always wrapped in requireStudentInScope.
+func (app *application) contextGetStudent(r *http.Request) *data.Student {
+	student, ok := r.Context().Value(studentContextKey).(*data.Student)
+	if !ok {
+		panic("missing student value in request context")
+	}
+	return student
+}
+
+// The contextSetToken() method returns a new copy of the request with the plaintext
+// authentication token added to the context.
+func (app *application) contextSetToken(r *http.Request, token string) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+// The contextGetToken() method returns the plaintext authentication token for the
+// request, or the empty string for anonymous requests.
+func (app *application) contextGetToken(r *http.Request) string {
+	token, _ := r.Context().Value(tokenContextKey).(string)
+	return token
+}
+
+// The contextSetRequestID() method returns a new copy of the request with the given
+// request ID added to the context.
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// The contextGetRequestID() method returns the ID of the current request, or an empty
+// string if the requestID middleware hasn't run.
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+// The contextSetRequestInfo() method returns a new copy of the request with the given
+// requestInfo added to the context.
+func (app *application) contextSetRequestInfo(r *http.Request, info *requestInfo) *http.Request {
+	ctx := context.WithValue(r.Context(), requestInfoContextKey, info)
+	return r.WithContext(ctx)
+}
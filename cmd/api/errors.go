@@ -1,25 +1,85 @@
 package main
 
 import (
-	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
+
+	"arnur.second.try/internal/i18n"
+	"arnur.second.try/internal/jsonlog"
+	"arnur.second.try/internal/validator"
 )
 
 func (app *application) logError(r *http.Request, err error) {
-	// Use the PrintError() method to log the error message, and include the current
-	// request method and URL as properties in the log entry.
-	app.logger.PrintError(err, map[string]string{
+	// Use the PrintError() method to log the error message, and include the request ID
+	// and the current request method and URL as properties in the log entry.
+	app.logger.PrintError(err, jsonlog.Properties{
+		"request_id":     app.contextGetRequestID(r),
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
 	})
 }
 
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
+	// Translate the message (or, for failed validations, every message in the map)
+	// into the locale of the request. Messages which aren't in the catalogs, such as
+	// most of the errors from readJSON(), are sent as they are.
+	locale := app.locale(r)
+	switch m := message.(type) {
+	case string:
+		message = i18n.Translate(locale, m)
+	case map[string]string:
+		translated := make(map[string]string, len(m))
+		for key, value := range m {
+			translated[key] = i18n.Translate(locale, value)
+		}
+		message = translated
+	case map[string][]validator.Error:
+		translated := make(map[string][]validator.Error, len(m))
+		for key, errs := range m {
+			for _, e := range errs {
+				e.Message = i18n.Translate(locale, e.Message)
+				translated[key] = append(translated[key], e)
+			}
+		}
+		message = translated
+	}
+	// The body depends on both the language and the media type the client asked for,
+	// so tell shared caches to key the response on both headers.
+	w.Header().Set("Content-Language", locale)
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Add("Vary", "Accept")
 	env := envelope{"error": message}
+	var headers http.Header
+	// Clients which accept application/problem+json get an RFC 7807 problem details
+	// object instead. The title is the standard status text, and as we don't define our
+	// own problem types the type is always "about:blank". A string message becomes the
+	// detail member, while the field errors of a failed validation go into an "errors"
+	// extension member.
+	if app.accepts(r, "application/problem+json") {
+		env = envelope{
+			"type":     "about:blank",
+			"title":    http.StatusText(status),
+			"status":   status,
+			"instance": r.URL.RequestURI(),
+		}
+		if id := app.contextGetRequestID(r); id != "" {
+			env["request_id"] = id
+		}
+		switch m := message.(type) {
+		case string:
+			env["detail"] = m
+		default:
+			env["detail"] = i18n.Translate(locale, "one or more fields failed validation")
+			env["errors"] = m
+		}
+		headers = http.Header{"Content-Type": []string{"application/problem+json"}}
+	}
 	// Write the response using the writeJSON() helper. If this happens to return an
 	// error then log it, and fall back to sending the client an empty response with a
 	// 500 Internal Server Error status code.
-	err := app.writeJSON(w, status, env, nil)
+	err := app.writeJSON(w, status, env, headers)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(500)
@@ -46,7 +106,7 @@ func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request)
 // The methodNotAllowedResponse() method will be used to send a 405 Method Not Allowed
 // status code and JSON response to the client.
 func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
-	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
+	message := app.translate(r, "the %s method is not supported for this resource", r.Method)
 	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
 }
 
@@ -54,10 +114,33 @@ func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Reques
 	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
 }
 
-// Note that the errors parameter here has the type map[string]string, which is exactly
-// the same as the errors map contained in our Validator type.
-func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+// The failedValidationResponse() method sends the errors collected by a Validator. By
+// default this is the original format, which maps each field to its first error
+// message. Clients which send "Accept: application/json; version=2" (or ask for
+// problem details) get every failed check for each field instead, with its code and
+// parameters, e.g.
+//
+//	{"error": {"name": [{"code": "max_length", "params": {"max": 500}, "message": "..."}]}}
+func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator) {
+	if app.acceptVersion(r) >= 2 || app.accepts(r, "application/problem+json") {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, v.Details)
+		return
+	}
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, v.Errors)
+}
+
+// The editConflictResponse() method sends a 409 Conflict response when a record has
+// been modified since the client (or the handler) last read it.
+func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
+	message := "unable to update the record due to an edit conflict, please try again"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+// The unsupportedMediaTypeResponse() method sends a 415 Unsupported Media Type response
+// when the request body is in a format that the endpoint doesn't understand.
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request) {
+	message := app.translate(r, "the %q content type is not supported for this resource", r.Header.Get("Content-Type"))
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
 }
 
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
@@ -84,3 +167,15 @@ func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Requ
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+// The rateLimitExceededResponse() method sends a 429 Too Many Requests response along
+// with a Retry-After header telling the client how many seconds to wait.
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	message := "rate limit exceeded"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
@@ -34,7 +34,7 @@ func (app *application) createFacultyHandler(w http.ResponseWriter, r *http.Requ
 	v := validator.New()
 
 	if data.ValidateFaculty(v, faculty); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 	err = app.models.Faculties.Insert(faculty)
@@ -46,6 +46,7 @@ func (app *application) createFacultyHandler(w http.ResponseWriter, r *http.Requ
 
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/faculties/%d", faculty.ID))
+	headers.Set("ETag", etag(faculty.Version))
 
 	err = app.writeJSON(w, http.StatusCreated, envelope{"faculty": faculty}, headers)
 	if err != nil {
@@ -73,7 +74,7 @@ func (app *application) showFacultyHandler(w http.ResponseWriter, r *http.Reques
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"faculty": faculty}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"faculty": faculty}, http.Header{"Etag": {etag(faculty.Version)}})
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -118,6 +119,9 @@ func (app *application) showFacultyStudentHandler(w http.ResponseWriter, r *http
 	input.Surname = app.readString(qs, "surname", "")
 	input.Age = app.readInt(qs, "age", 0, v)
 	input.FacultyId = app.readInt(qs, "faculty_id", int(faculty_id), v)
+	if _, scoped := app.contextGetFacultyScope(r); scoped {
+		input.FacultyId = int(faculty_id)
+	}
 	input.StudyYear = app.readInt(qs, "study_year", 0, v)
 
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
@@ -129,7 +133,7 @@ func (app *application) showFacultyStudentHandler(w http.ResponseWriter, r *http
 	input.Filters.SortSafelist = []string{"id", "name", "surname", "runtime", "study_year", "age", "faculty_id", "-id", "-name", "-surname", "-runtime", "-study_year", "-age", "-faculty_id"}
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 	// Call the GetAll() method to retrieve the movies, passing in the various filter
@@ -166,6 +170,17 @@ func (app *application) updateFacultyHandler(w http.ResponseWriter, r *http.Requ
 		}
 		return
 	}
+	// If the client told us which version of the record it edited, make sure that is
+	// still the current version before going any further.
+	match, err := app.versionMatches(r, faculty.Version)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if !match {
+		app.editConflictResponse(w, r)
+		return
+	}
 	// Declare an input struct to hold the expected data from the client.
 	var input struct {
 		Founder string `json:"founder"`
@@ -189,17 +204,100 @@ func (app *application) updateFacultyHandler(w http.ResponseWriter, r *http.Requ
 	// response if any checks fail.
 	v := validator.New()
 	if data.ValidateFaculty(v, faculty); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 	// Pass the updated movie record to our new Update() method.
 	err = app.models.Faculties.Update(faculty)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 	// Write the updated movie record in a JSON response.
-	err = app.writeJSON(w, http.StatusOK, envelope{"faculty": faculty}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"faculty": faculty}, http.Header{"Etag": {etag(faculty.Version)}})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) patchFacultyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	faculty, err := app.models.Faculties.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	match, err := app.versionMatches(r, faculty.Version)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if !match {
+		app.editConflictResponse(w, r)
+		return
+	}
+	// Use pointers for the fields so that we can tell a key which is missing from the
+	// request body (nil) apart from one which was sent with its zero value.
+	var input struct {
+		Founder *string `json:"founder"`
+		Title   *string `json:"title"`
+		Year    *int32  `json:"year"`
+		Runtime *int32  `json:"runtime"`
+	}
+	err = app.readPatchJSON(w, r, &input)
+	if err != nil {
+		switch {
+		case errors.Is(err, errUnsupportedMediaType):
+			app.unsupportedMediaTypeResponse(w, r)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	// Only copy over the fields which were present in the request body.
+	if input.Founder != nil {
+		faculty.Founder = *input.Founder
+	}
+	if input.Title != nil {
+		faculty.Title = *input.Title
+	}
+	if input.Year != nil {
+		faculty.Year = *input.Year
+	}
+	if input.Runtime != nil {
+		faculty.Runtime = *input.Runtime
+	}
+	// The patched record must still be a valid faculty as a whole.
+	v := validator.New()
+	if data.ValidateFaculty(v, faculty); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+	err = app.models.Faculties.Update(faculty)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"faculty": faculty}, http.Header{"Etag": {etag(faculty.Version)}})
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -225,7 +323,49 @@ func (app *application) deleteFacultyHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	// Return a 200 OK status code along with a success message.
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "faculty successfully deleted"}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": app.translate(r, "faculty successfully deleted")}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listFacultiesHandler(w http.ResponseWriter, r *http.Request) {
+	// Define an input struct to hold the expected values from the request query
+	// string, in the same way as listStudentsHandler.
+	var input struct {
+		Founder string
+		Title   string
+		Year    int
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Founder = app.readString(qs, "founder", "")
+	input.Title = app.readString(qs, "title", "")
+	input.Year = app.readInt(qs, "year", 0, v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	// Extract the sort query string value, falling back to "id" if it is not provided
+	// by the client (which will imply an ascending sort on faculty ID).
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "founder", "title", "year", "runtime", "-id", "-founder", "-title", "-year", "-runtime"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	faculties, metadata, err := app.models.Faculties.GetAll(input.Founder, input.Title, input.Year, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"faculties": faculties, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -1,15 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 
+	"arnur.second.try/internal/data"
+	"arnur.second.try/internal/i18n"
 	"arnur.second.try/internal/validator"
 	"github.com/julienschmidt/httprouter"
 )
@@ -23,6 +27,43 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// The versionMatches() helper checks the record version that the client asserts it
+// edited against the current version of the record. The version is taken from the
+// If-Match header, which holds entity tags as sent in our ETag headers, or else from
+// the X-Expected-Version header, which holds a bare number. Following RFC 9110, an
+// If-Match of * matches any current version, and a comma-separated list of tags
+// matches if any of them does. If neither header was sent there is nothing to check,
+// so the version always matches.
+func (app *application) versionMatches(r *http.Request, current int32) (bool, error) {
+	value := r.Header.Get("If-Match")
+	if value == "" {
+		value = r.Header.Get("X-Expected-Version")
+	}
+	value = strings.TrimSpace(value)
+	if value == "" || value == "*" {
+		return true, nil
+	}
+	match := false
+	for _, tag := range strings.Split(value, ",") {
+		// Accept bare numbers as well as (weak) entity tags such as "3" or W/"3".
+		tag = strings.Trim(strings.TrimPrefix(strings.TrimSpace(tag), "W/"), `"`)
+		version, err := strconv.ParseInt(tag, 10, 32)
+		if err != nil || version < 1 {
+			return false, errors.New("invalid expected version header")
+		}
+		if int32(version) == current {
+			match = true
+		}
+	}
+	return match, nil
+}
+
+// The etag() helper returns the entity tag for a record version, which clients can
+// send back in an If-Match header when they update the record.
+func etag(version int32) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
 type envelope map[string]interface{}
 
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
@@ -31,10 +72,12 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data envelo
 		return err
 	}
 	js = append(js, '\n')
+	// Set the default Content-Type first, so that the headers passed in can override
+	// it (problem details use application/problem+json, for example).
+	w.Header().Set("Content-Type", "application/json")
 	for key, value := range headers {
 		w.Header()[key] = value
 	}
-	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	w.Write(js)
 	return nil
@@ -90,6 +133,44 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 	return nil
 }
 
+// errUnsupportedMediaType is returned by readPatchJSON() when the request body isn't
+// in one of the formats that we accept for partial updates.
+var errUnsupportedMediaType = errors.New("unsupported media type")
+
+// The readPatchJSON() helper decodes the body of a PATCH request into dst, which is
+// expected to be a struct of pointer fields so that absent keys stay nil. Both plain
+// application/json and RFC 7396 application/merge-patch+json bodies are accepted. In a
+// merge patch a null member means "remove this field", and since every field we allow
+// to be patched is required, we reject those instead of silently ignoring them.
+func (app *application) readPatchJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || (mediaType != "application/json" && mediaType != "application/merge-patch+json") {
+			return errUnsupportedMediaType
+		}
+	}
+	// Read the body as a JSON object first, so that we get all the usual checks on
+	// its size and syntax and can inspect the individual members.
+	var members map[string]json.RawMessage
+	err := app.readJSON(w, r, &members)
+	if err != nil {
+		return err
+	}
+	for key, value := range members {
+		if string(value) == "null" {
+			return fmt.Errorf("body must not remove required key %q", key)
+		}
+	}
+	// Then decode the same members into the destination struct, letting readJSON()
+	// report any unknown keys or incorrect types.
+	js, err := json.Marshal(members)
+	if err != nil {
+		return err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(js))
+	return app.readJSON(w, r, dst)
+}
+
 // The readString() helper returns a string value from the query string, or the provided
 // default value if no matching key could be found.
 func (app *application) readString(qs url.Values, key string, defaultValue string) string {
@@ -119,23 +200,74 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	// validator instance and return the default value.
 	i, err := strconv.Atoi(s)
 	if err != nil {
-		v.AddError(key, "must be an integer value")
+		v.AddCodedError(key, validator.CodeInvalidFormat, "must be an integer value", nil)
 		return defaultValue
 	}
 	// Otherwise, return the converted integer value.
 	return i
 }
 
-func (app *application) background(fn func()) {
-	// Launch a background goroutine.
-	go func() {
-		// Recover any panic.
-		defer func() {
-			if err := recover(); err != nil {
-				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+// The locale() helper returns the language to respond in. That is the authenticated
+// user's stored preference if they have one, otherwise the best match for the
+// Accept-Language header. Errors can be sent before the authenticate middleware has
+// run, so a missing user in the context is not a problem here.
+func (app *application) locale(r *http.Request) string {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if ok && user.Locale != "" {
+		return user.Locale
+	}
+	return i18n.Match(r.Header.Get("Accept-Language"))
+}
+
+// The emailLocale() helper returns the language for an email to the given user, who
+// isn't necessarily the one making the request (think of a password reset).
+func (app *application) emailLocale(r *http.Request, user *data.User) string {
+	if user.Locale != "" {
+		return user.Locale
+	}
+	return i18n.Match(r.Header.Get("Accept-Language"))
+}
+
+// The translate() helper translates a message from the catalogs into the locale of the
+// request.
+func (app *application) translate(r *http.Request, message string, args ...interface{}) string {
+	return i18n.Translate(app.locale(r), message, args...)
+}
+
+// The accepts() helper reports whether the Accept header of the request explicitly
+// lists the given media type. An entry with q=0 means that the client does not accept
+// that media type, so it doesn't count.
+func (app *application) accepts(r *http.Request, mediaType string) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(accept, ",") {
+			accepted, params, err := mime.ParseMediaType(part)
+			if err != nil || accepted != mediaType {
+				continue
+			}
+			if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+				continue
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// The acceptVersion() helper returns the version of the response format requested with
+// a version parameter in the Accept header, such as "application/json; version=2". It
+// returns 1, the original format, if the client didn't ask for a version.
+func (app *application) acceptVersion(r *http.Request) int {
+	version := 1
+	for _, accept := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(part)
+			if err != nil || (mediaType != "application/json" && mediaType != "*/*") {
+				continue
+			}
+			if n, err := strconv.Atoi(params["version"]); err == nil && n > version {
+				version = n
 			}
-		}()
-		// Execute the arbitrary function that we passed as the parameter.
-		fn()
-	}()
+		}
+	}
+	return version
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+
+	"arnur.second.try/internal/jsonlog"
+	"arnur.second.try/internal/validator"
+)
+
+// showLogLevelHandler returns the minimum level the application is currently logging at.
+func (app *application) showLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"level": app.logger.Level().String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateLogLevelHandler changes the minimum log level of the running process, so that
+// DEBUG entries can be switched on while investigating a problem without restarting
+// the server. The change only lasts until the next restart, when the -log-level flag
+// applies again.
+func (app *application) updateLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Level string `json:"level"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	level, err := jsonlog.ParseLevel(input.Level)
+	v.CheckCode(input.Level != "", "level", validator.CodeRequired, "must be provided", nil)
+	if input.Level != "" {
+		v.CheckCode(err == nil, "level", validator.CodeNotAllowed, "must be one of debug, info, warn, error, fatal or off",
+			validator.Params{"allowed": []string{"debug", "info", "warn", "error", "fatal", "off"}})
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	previous := app.logger.Level()
+	app.logger.SetLevel(level)
+	// Log the change at WARN, so that it shows up at every level short of ERROR.
+	app.logger.PrintWarn("log level changed", jsonlog.Properties{
+		"request_id": app.contextGetRequestID(r),
+		"from":       previous.String(),
+		"to":         level.String(),
+		"user_id":    app.contextGetUser(r).ID,
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"level": level.String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"arnur.second.try/internal/metrics"
+	"github.com/julienschmidt/httprouter"
+)
+
+// unmatchedRoute is the route label for requests which never reached a handler, such
+// as unknown URLs and requests turned away by the rate limiter. Using the raw URL for
+// these would let anybody create as many series as they like.
+const unmatchedRoute = "unmatched"
+
+// otherMethod is the method label for requests with a method that isn't one of the
+// standard HTTP methods. The method comes straight from the client, so it needs the
+// same protection as the route.
+const otherMethod = "other"
+
+// methodLabel returns the method label for the metrics of a request.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return otherMethod
+	}
+}
+
+// appMetrics holds the metrics which are updated while the application runs. The
+// database and runtime metrics are read straight from their source on every scrape.
+type appMetrics struct {
+	registry        *metrics.Registry
+	requests        *metrics.Counter
+	requestDuration *metrics.Histogram
+	mailSends       *metrics.Counter
+}
+
+func newMetrics(db *sql.DB) *appMetrics {
+	registry := metrics.NewRegistry()
+	m := &appMetrics{
+		registry:        registry,
+		requests:        registry.NewCounter("hogwarts_http_requests_total", "Number of HTTP requests handled.", "method", "route", "status"),
+		requestDuration: registry.NewHistogram("hogwarts_http_request_duration_seconds", "Time taken to handle HTTP requests.", metrics.DefaultBuckets, "method", "route"),
+		mailSends:       registry.NewCounter("hogwarts_mail_sends_total", "Number of attempts to send an email from the outbox.", "result"),
+	}
+
+	// Build information is exposed as a constant 1 with the details in the labels,
+	// which is the usual Prometheus convention for it.
+	revision := "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				revision = setting.Value
+			}
+		}
+	}
+	registry.NewGauge("hogwarts_build_info", "Build information about the running binary.", "version", "revision", "goversion").
+		Set(1, version, revision, runtime.Version())
+
+	registry.NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+
+	// The state of the connection pool, read from sql.DB.Stats().
+	registry.NewGaugeFunc("hogwarts_db_max_open_connections", "Maximum number of open connections to the database.", func() float64 {
+		return float64(db.Stats().MaxOpenConnections)
+	})
+	registry.NewGaugeFunc("hogwarts_db_open_connections", "Number of established connections, both in use and idle.", func() float64 {
+		return float64(db.Stats().OpenConnections)
+	})
+	registry.NewGaugeFunc("hogwarts_db_in_use_connections", "Number of connections currently in use.", func() float64 {
+		return float64(db.Stats().InUse)
+	})
+	registry.NewGaugeFunc("hogwarts_db_idle_connections", "Number of idle connections.", func() float64 {
+		return float64(db.Stats().Idle)
+	})
+	registry.NewCounterFunc("hogwarts_db_wait_count_total", "Total number of connections waited for.", func() float64 {
+		return float64(db.Stats().WaitCount)
+	})
+	registry.NewCounterFunc("hogwarts_db_wait_duration_seconds_total", "Total time blocked waiting for a new connection.", func() float64 {
+		return db.Stats().WaitDuration.Seconds()
+	})
+	registry.NewCounterFunc("hogwarts_db_max_idle_closed_total", "Total number of connections closed due to the idle connection limit.", func() float64 {
+		return float64(db.Stats().MaxIdleClosed)
+	})
+	registry.NewCounterFunc("hogwarts_db_max_idle_time_closed_total", "Total number of connections closed due to the idle time limit.", func() float64 {
+		return float64(db.Stats().MaxIdleTimeClosed)
+	})
+	registry.NewCounterFunc("hogwarts_db_max_lifetime_closed_total", "Total number of connections closed due to the maximum lifetime limit.", func() float64 {
+		return float64(db.Stats().MaxLifetimeClosed)
+	})
+
+	return m
+}
+
+// The recordMetrics middleware counts every request and how long it took, by method,
+// route pattern and status code. It has to run inside logRequest, which puts the
+// requestInfo that the router fills the route pattern into in the context.
+func (app *application) recordMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		route := unmatchedRoute
+		if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok && info.route != "" {
+			route = info.route
+		}
+		method := methodLabel(r.Method)
+		app.metrics.requests.Inc(method, route, strconv.Itoa(rec.status))
+		app.metrics.requestDuration.Observe(time.Since(start).Seconds(), method, route)
+	})
+}
+
+// routeTable is a httprouter.Router which remembers the pattern of the route that
+// handled a request, so that the metrics can be labelled with "/v1/students/:id"
+// rather than with every student ID. Everything apart from HandlerFunc() is simply
+// passed through to the embedded router.
+type routeTable struct {
+	*httprouter.Router
+}
+
+func newRouteTable() routeTable {
+	return routeTable{httprouter.New()}
+}
+
+func (t routeTable) HandlerFunc(method, pattern string, handler http.HandlerFunc) {
+	t.Router.HandlerFunc(method, pattern, func(w http.ResponseWriter, r *http.Request) {
+		if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+			info.route = pattern
+		}
+		handler(w, r)
+	})
+}
+
+// metricsRoutes returns the handler for the separate metrics listener. It is kept
+// apart from the API routes so that the metrics are never reachable from the public
+// port.
+func (app *application) metricsRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", app.metrics.registry.Handler())
+	return mux
+}
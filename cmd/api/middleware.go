@@ -1,16 +1,126 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors" // New import
 	"fmt"
-
+	"net"
 	"net/http"
 	"strings" // New import
+	"sync"
+	"time"
 
 	"arnur.second.try/internal/data"
+	"arnur.second.try/internal/jsonlog"
 	"arnur.second.try/internal/validator"
+	"golang.org/x/time/rate"
 )
 
+// The requestID middleware makes sure every request has an ID. A well-formed ID sent
+// by the client (or by a proxy in front of us) in the X-Request-ID header is kept, so
+// that the same ID can be followed through every service, otherwise we generate a
+// random one. The ID is stored in the request context and echoed in the response.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			b := make([]byte, 16)
+			_, err := rand.Read(b)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, app.contextSetRequestID(r, id))
+	})
+}
+
+// validRequestID only accepts short IDs made of letters, digits, dots, dashes and
+// underscores, so that a client can't inject anything odd into our logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// responseRecorder wraps a http.ResponseWriter to remember the status code and the
+// number of bytes written, which are only known once the handler has finished.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if !rr.wroteHeader {
+		rr.status = status
+		rr.wroteHeader = true
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if !rr.wroteHeader {
+		rr.WriteHeader(http.StatusOK)
+	}
+	n, err := rr.ResponseWriter.Write(b)
+	rr.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the original ResponseWriter.
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
+// The logRequest middleware writes one access log entry for every request once it has
+// been handled. The authenticated user is only known further down the chain, so the
+// authenticate middleware reports it back through the requestInfo in the context.
+func (app *application) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		info := &requestInfo{}
+		next.ServeHTTP(rec, app.contextSetRequestInfo(r, info))
+
+		properties := jsonlog.Properties{
+			"request_id":     app.contextGetRequestID(r),
+			"request_method": r.Method,
+			"request_url":    r.URL.String(),
+			"status":         rec.status,
+			"bytes":          rec.bytes,
+			"duration":       time.Since(start),
+			"remote_ip":      remoteIP(r),
+		}
+		if info.userID != 0 {
+			properties["user_id"] = info.userID
+		}
+		app.logger.PrintInfo("request", properties)
+	})
+}
+
+// remoteIP returns the IP address of the client, without the port.
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
 func (app *application) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Create a deferred function (which will always be run in the event of a panic
@@ -36,6 +146,159 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
+// rateLimiter holds a token bucket for every client, keyed by either the client IP
+// address or the authenticated user ID, along with the time each client was last seen.
+type rateLimiter struct {
+	mu      sync.Mutex
+	clients map[string]*rateLimitClient
+	rps     float64
+	burst   int
+}
+
+type rateLimitClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newRateLimiter(rps float64, burst int) *rateLimiter {
+	return &rateLimiter{clients: make(map[string]*rateLimitClient), rps: rps, burst: burst}
+}
+
+// allow takes a token from the bucket of the given client. If one isn't immediately
+// available the reservation is cancelled, so the client isn't charged for the rejected
+// request, and the time they should wait before retrying is returned instead.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	reservation := l.client(key).limiter.Reserve()
+	delay := reservation.Delay()
+	if !reservation.OK() || delay > 0 {
+		reservation.Cancel()
+		return false, delay
+	}
+	return true, 0
+}
+
+// available reports whether the bucket of the given client has a token left, without
+// taking it. If it hasn't, the time until it will have one is returned as well.
+func (l *rateLimiter) available(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	tokens := l.client(key).limiter.TokensAt(time.Now())
+	if tokens >= 1 {
+		return true, 0
+	}
+	return false, time.Duration((1 - tokens) / l.rps * float64(time.Second))
+}
+
+// charge takes a token from the bucket of the given client even if it is empty. The
+// bucket then goes into debt, and the client has to wait for it to fill up again.
+func (l *rateLimiter) charge(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.client(key).limiter.Reserve()
+}
+
+// client returns the bucket of the given client, creating it if necessary. The mutex
+// must be held by the caller.
+func (l *rateLimiter) client(key string) *rateLimitClient {
+	c, found := l.clients[key]
+	if !found {
+		c = &rateLimitClient{limiter: rate.NewLimiter(rate.Limit(l.rps), l.burst)}
+		l.clients[key] = c
+	}
+	c.lastSeen = time.Now()
+	return c
+}
+
+// sweep is the janitor of the rate limiter. Once every minute it removes the clients
+// which haven't been seen for three minutes, until ctx is cancelled.
+func (l *rateLimiter) sweep(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		l.mu.Lock()
+		for key, c := range l.clients {
+			if time.Since(c.lastSeen) > 3*time.Minute {
+				delete(l.clients, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// The rateLimit middleware limits anonymous clients by IP address. It runs before
+// authenticate, so that a client guessing bearer tokens is throttled like anybody
+// else, and a throttled request doesn't cost a token lookup.
+//
+// Requests with a bearer token are limited by user instead (see rateLimitUser), so
+// that users sharing an address, behind a NAT say, don't use up each other's requests.
+// For those we only check that the address has a token left here, and charge the
+// address afterwards if the request didn't turn out to be authenticated. A client
+// trying out tokens therefore runs its address into debt, and is refused before any
+// more of its tokens are looked up.
+func (app *application) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Only carry out the check if rate limiting is enabled.
+		if !app.config.limiter.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		key := "ip:" + ip
+		if r.Header.Get("Authorization") == "" {
+			if ok, delay := app.limiter.allow(key); !ok {
+				app.rateLimitExceededResponse(w, r, delay)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if ok, delay := app.limiter.available(key); !ok {
+			app.rateLimitExceededResponse(w, r, delay)
+			return
+		}
+		info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo)
+		if !ok {
+			// Without the requestInfo we can't tell whether the request was
+			// authenticated, so just treat it like an anonymous one.
+			info = &requestInfo{}
+			r = app.contextSetRequestInfo(r, info)
+		}
+		next.ServeHTTP(w, r)
+		if info.userID == 0 {
+			app.limiter.charge(key)
+		}
+	})
+}
+
+// The rateLimitUser middleware runs after authenticate and limits every authenticated
+// user by their own bucket, regardless of which address they connect from.
+func (app *application) rateLimitUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+		if !app.config.limiter.enabled || user.IsAnonymous() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, delay := app.limiter.allow(fmt.Sprintf("user:%d", user.ID)); !ok {
+			app.rateLimitExceededResponse(w, r, delay)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Add the "Vary: Authorization" header to the response. This indicates to any
@@ -89,9 +352,20 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			}
 			return
 		}
+		// Record when the token was last used, for the list of sessions. This is only
+		// informational, so a failure is logged and the request carries on rather than
+		// turning into a 500.
+		err = app.models.Tokens.Touch(token)
+		if err != nil {
+			app.logger.PrintWarn("unable to record token use: "+err.Error(), jsonlog.Properties{
+				"request_id": app.contextGetRequestID(r),
+				"user_id":    user.ID,
+			})
+		}
 		// Call the contextSetUser() helper to add the user information to the request
-		// context.
+		// context, along with the token itself so that it can be revoked on logout.
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, token)
 		// Call the next handler in the chain.
 		next.ServeHTTP(w, r)
 	})
@@ -146,3 +420,59 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 	// Wrap this with the requireActivatedUser() middleware before returning it.
 	return app.requireActivatedUser(fn)
 }
+
+// The requireScopedPermission() middleware works like requirePermission(), except that
+// a user who only holds the scoped variant of the code (for example
+// "students:write:own_faculty" instead of "students:write") is also let through. For
+// those users the request is limited to their own faculty, which the handlers and
+// requireStudentInScope() enforce for each resource.
+func (app *application) requireScopedPermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		switch {
+		case permissions.Include(code):
+			next.ServeHTTP(w, r)
+		case permissions.Include(code+":own_faculty") && user.FacultyID != nil:
+			r = app.contextSetFacultyScope(r, *user.FacultyID)
+			next.ServeHTTP(w, r)
+		default:
+			app.notPermittedResponse(w, r)
+		}
+	}
+	return app.requireActivatedUser(fn)
+}
+
+// The requireStudentInScope() middleware is used on the /v1/students/:id routes. It
+// looks up the student, and if the request is limited to a faculty it only calls the
+// next handler if the student belongs to that faculty. The student is added to the
+// request context, so that the handlers don't have to fetch it a second time.
+func (app *application) requireStudentInScope(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+		student, err := app.models.Students.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		if !app.facultyInScope(r, student.FacultyId) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+		r = app.contextSetStudent(r, student)
+		next.ServeHTTP(w, r)
+	}
+}
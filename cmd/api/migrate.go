@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"arnur.second.try/internal/jsonlog"
+	"arnur.second.try/internal/migrate"
+)
+
+// The migrateCommand() method runs one of the -migrate modes (up, down, status or
+// force N) against the database and returns, rather than starting the HTTP server.
+// The version for force can be given either as part of the flag value ("force 3") or
+// as the first positional argument.
+func (app *application) migrateCommand(migrator *migrate.Migrator, command string, args []string) error {
+	fields := append(strings.Fields(command), args...)
+	if len(fields) == 0 {
+		return errors.New("missing migrate command")
+	}
+
+	switch fields[0] {
+	case "up":
+		applied, err := migrator.Up()
+		if err != nil {
+			return err
+		}
+		app.logger.PrintInfo("database migrations applied", jsonlog.Properties{
+			"count": applied,
+		})
+
+	case "down":
+		version, err := migrator.Down()
+		if err != nil {
+			if errors.Is(err, migrate.ErrNoChange) {
+				app.logger.PrintInfo("no database migrations to roll back", nil)
+				return nil
+			}
+			return err
+		}
+		app.logger.PrintInfo("database migration rolled back", jsonlog.Properties{
+			"version": version,
+		})
+
+	case "status":
+		status, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		app.logger.PrintInfo("database migration status", jsonlog.Properties{
+			"version": status.Version,
+			"latest":  status.Latest,
+			"dirty":   status.Dirty,
+		})
+
+	case "force":
+		if len(fields) != 2 {
+			return errors.New("force requires exactly one version argument")
+		}
+		version, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid force version %q", fields[1])
+		}
+		err = migrator.Force(version)
+		if err != nil {
+			return err
+		}
+		app.logger.PrintInfo("database migration version forced", jsonlog.Properties{
+			"version": version,
+		})
+
+	default:
+		return fmt.Errorf("unknown migrate command %q (expected up|down|status|force N)", fields[0])
+	}
+
+	return nil
+}
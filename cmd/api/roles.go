@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+
+	"arnur.second.try/internal/data"
+	"arnur.second.try/internal/validator"
+)
+
+func (app *application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.models.Roles.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showUserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.readUserParam(w, r)
+	if user == nil {
+		return
+	}
+	app.writeUserRoles(w, r, user)
+}
+
+func (app *application) assignUserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	app.changeUserRoles(w, r, app.models.Roles.AddForUser)
+}
+
+func (app *application) unassignUserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	app.changeUserRoles(w, r, app.models.Roles.RemoveForUser)
+}
+
+// changeUserRoles holds the logic shared by the PUT and DELETE handlers, which both
+// expect a body in the format {"roles": ["prefect"]}.
+func (app *application) changeUserRoles(w http.ResponseWriter, r *http.Request, change func(actorID, userID int64, names ...string) error) {
+	user := app.readUserParam(w, r)
+	if user == nil {
+		return
+	}
+
+	var input struct {
+		Roles []string `json:"roles"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	known, err := app.models.Roles.Names()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateRoleNames(v, input.Roles, known); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	actor := app.contextGetUser(r)
+	err = change(actor.ID, user.ID, input.Roles...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.writeUserRoles(w, r, user)
+}
+
+// writeUserRoles responds with a user's roles together with the effective set of
+// permissions that they resolve to.
+func (app *application) writeUserRoles(w http.ResponseWriter, r *http.Request, user *data.User) {
+	roles, err := app.models.Roles.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user, "roles": roles, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -2,36 +2,75 @@ package main
 
 import (
 	"net/http"
-
-	"github.com/julienschmidt/httprouter"
 )
 
 func (app *application) routes() http.Handler {
 
-	router := httprouter.New()
+	router := newRouteTable()
+
+	// httprouter doesn't allow a wildcard segment to sit next to static ones, so the
+	// routes under /v1/users/:id can't live alongside /v1/users/activated. They are
+	// registered on a second router instead, which the main router falls back to for
+	// any request that it can't match itself.
+	userRouter := newRouteTable()
+
+	userRouter.NotFound = http.HandlerFunc(app.notFoundResponse)
 
-	router.NotFound = http.HandlerFunc(app.notFoundResponse)
+	userRouter.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
+
+	router.NotFound = userRouter
 
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
 
+	router.HandlerFunc(http.MethodGet, "/v1/faculties", app.requirePermission("faculties:read", app.listFacultiesHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/faculties", app.requirePermission("faculties:write", app.createFacultyHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/faculties/:id", app.requirePermission("faculties:read", app.showFacultyHandler))
 	router.HandlerFunc(http.MethodPut, "/v1/faculties/:id", app.requirePermission("faculties:write", app.updateFacultyHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/faculties/:id", app.requirePermission("faculties:write", app.patchFacultyHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/faculties/:id", app.requirePermission("faculties:write", app.deleteFacultyHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/faculties/:id/students", app.requirePermission("faculties:read", app.showFacultyStudentHandler))
 
-	router.HandlerFunc(http.MethodGet, "/v1/students", app.requirePermission("students:read", app.listStudentsHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/students", app.requirePermission("students:write", app.createStudentHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/students/:id", app.requirePermission("students:read", app.showStudentHandler))
-	router.HandlerFunc(http.MethodPut, "/v1/students/:id", app.requirePermission("students:write", app.updateStudentHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/students/:id", app.requirePermission("students:write", app.deleteStudentHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/students", app.requireScopedPermission("students:read", app.listStudentsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/students", app.requireScopedPermission("students:write", app.createStudentHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/students/:id", app.requireScopedPermission("students:read", app.requireStudentInScope(app.showStudentHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/students/:id", app.requireScopedPermission("students:write", app.requireStudentInScope(app.updateStudentHandler)))
+	router.HandlerFunc(http.MethodPatch, "/v1/students/:id", app.requireScopedPermission("students:write", app.requireStudentInScope(app.patchStudentHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/students/:id", app.requireScopedPermission("students:write", app.requireStudentInScope(app.deleteStudentHandler)))
 
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/sessions", app.requireAuthenticatedUser(app.listUserSessionsHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/locale", app.requireAuthenticatedUser(app.updateUserLocaleHandler))
 
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requireAuthenticatedUser(app.deleteAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication/all", app.requireAuthenticatedUser(app.deleteAllAuthenticationTokensHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/permissions", app.requirePermission("users:admin", app.listPermissionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/permissions/:code/users", app.requirePermission("users:admin", app.listPermissionUsersHandler))
+
+	userRouter.HandlerFunc(http.MethodGet, "/v1/users/:id/permissions", app.requirePermission("users:admin", app.showUserPermissionsHandler))
+	userRouter.HandlerFunc(http.MethodPut, "/v1/users/:id/permissions", app.requirePermission("users:admin", app.grantUserPermissionsHandler))
+	userRouter.HandlerFunc(http.MethodDelete, "/v1/users/:id/permissions", app.requirePermission("users:admin", app.revokeUserPermissionsHandler))
+	userRouter.HandlerFunc(http.MethodGet, "/v1/users/:id/permissions/audit", app.requirePermission("users:admin", app.showUserPermissionsAuditHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/roles", app.requirePermission("users:admin", app.listRolesHandler))
+
+	userRouter.HandlerFunc(http.MethodGet, "/v1/users/:id/roles", app.requirePermission("users:admin", app.showUserRolesHandler))
+	userRouter.HandlerFunc(http.MethodPut, "/v1/users/:id/roles", app.requirePermission("users:admin", app.assignUserRolesHandler))
+	userRouter.HandlerFunc(http.MethodDelete, "/v1/users/:id/roles", app.requirePermission("users:admin", app.unassignUserRolesHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/outbox", app.requirePermission("users:admin", app.listOutboxHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/outbox/:id", app.requirePermission("users:admin", app.showOutboxEmailHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/outbox/:id/requeue", app.requirePermission("users:admin", app.requeueOutboxEmailHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/log-level", app.requirePermission("users:admin", app.showLogLevelHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/log-level", app.requirePermission("users:admin", app.updateLogLevelHandler))
 
-	return app.recoverPanic((app.authenticate(router)))
+	return app.requestID(app.logRequest(app.recordMetrics(app.recoverPanic(app.rateLimit(app.authenticate(app.rateLimitUser(router)))))))
 }
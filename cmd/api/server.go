@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"arnur.second.try/internal/jsonlog"
+)
+
+func (app *application) serve() error {
+	srv := &http.Server{
+		Addr:         fmt.Sprintf(":%d", app.config.port),
+		Handler:      app.routes(),
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		// The server logs problems such as failed TLS handshakes and broken client
+		// connections. They are caused by clients rather than by us, so they are
+		// written at the WARN level.
+		ErrorLog: slog.NewLogLogger(jsonlog.NewHandler(app.logger), slog.LevelWarn),
+	}
+
+	// The metrics are served by a second server on their own address, which is
+	// normally only reachable from inside the network. It is shut down together with
+	// the main server. The listener is opened here rather than in the goroutine, so
+	// that an address which is already in use stops the application at startup
+	// instead of leaving it running without metrics.
+	var metricsSrv *http.Server
+	if app.config.metrics.addr != "" {
+		metricsSrv = &http.Server{
+			Addr:         app.config.metrics.addr,
+			Handler:      app.metricsRoutes(),
+			IdleTimeout:  time.Minute,
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+			ErrorLog:     srv.ErrorLog,
+		}
+		ln, err := net.Listen("tcp", metricsSrv.Addr)
+		if err != nil {
+			return err
+		}
+		app.logger.PrintInfo("starting metrics server", jsonlog.Properties{
+			"addr": metricsSrv.Addr,
+		})
+		go func() {
+			err := metricsSrv.Serve(ln)
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.PrintError(err, jsonlog.Properties{
+					"addr": metricsSrv.Addr,
+				})
+			}
+		}()
+	}
+
+	// Start the workers which deliver the emails from the outbox, and the janitor of
+	// the rate limiter. They are all stopped through backgroundCancel once the server
+	// has shut down.
+	backgroundCtx, backgroundCancel := context.WithCancel(context.Background())
+	defer backgroundCancel()
+	app.startOutboxWorkers(backgroundCtx)
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		app.limiter.sweep(backgroundCtx)
+	}()
+
+	// Create a shutdownError channel. We will use this to receive any errors returned
+	// by the graceful Shutdown() function.
+	shutdownError := make(chan error)
+
+	// Start a background goroutine which listens for SIGINT and SIGTERM signals.
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		// Read the signal from the quit channel. This code will block until a signal
+		// is received.
+		s := <-quit
+
+		app.logger.PrintInfo("shutting down server", jsonlog.Properties{
+			"signal": s.String(),
+		})
+
+		// Give in-flight requests the configured amount of time to complete.
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdownTimeout)
+		defer cancel()
+
+		// Shutdown() stops accepting new connections and waits for active requests to
+		// finish. If it returns an error we relay it straight away, otherwise we carry
+		// on and wait for the background tasks.
+		if metricsSrv != nil {
+			metricsSrv.Shutdown(ctx)
+		}
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			shutdownError <- err
+			return
+		}
+
+		app.logger.PrintInfo("completing background tasks", jsonlog.Properties{
+			"addr": srv.Addr,
+		})
+
+		// Tell the outbox workers and the rate limiter janitor to stop, then block
+		// until they have finished. Any email still waiting in the outbox is picked up
+		// again by the next instance.
+		backgroundCancel()
+		app.wg.Wait()
+		shutdownError <- nil
+	}()
+
+	app.logger.PrintInfo("starting server", jsonlog.Properties{
+		"addr": srv.Addr,
+		"env":  app.config.env,
+	})
+
+	// Calling Shutdown() on our server will cause ListenAndServe() to immediately
+	// return a http.ErrServerClosed error. So if we see this error, it is actually a
+	// good thing and an indication that the graceful shutdown has started.
+	err := srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	// Otherwise, we wait to receive the return value from Shutdown() on the
+	// shutdownError channel.
+	err = <-shutdownError
+	if err != nil {
+		return err
+	}
+
+	app.logger.PrintInfo("stopped server", jsonlog.Properties{
+		"addr": srv.Addr,
+	})
+
+	return nil
+}
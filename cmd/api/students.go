@@ -34,7 +34,12 @@ func (app *application) createStudentHandler(w http.ResponseWriter, r *http.Requ
 	}
 	v := validator.New()
 	if data.ValidateStudent(v, student); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+	// Users limited to their own faculty can only add students to it.
+	if !app.facultyInScope(r, student.FacultyId) {
+		app.notPermittedResponse(w, r)
 		return
 	}
 
@@ -45,6 +50,7 @@ func (app *application) createStudentHandler(w http.ResponseWriter, r *http.Requ
 	}
 
 	headers := make(http.Header)
+	headers.Set("ETag", etag(student.Version))
 
 	// headers.Set("Location", fmt.Sprintf("v1/students/%d", student.ID))
 	// fmt.Fprintf(w, "%+v\n", input)
@@ -56,47 +62,27 @@ func (app *application) createStudentHandler(w http.ResponseWriter, r *http.Requ
 }
 
 func (app *application) showStudentHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := app.readIDParam(r)
-	if err != nil {
-		app.notFoundResponse(w, r)
-		return
-	}
-	// Call the Get() method to fetch the data for a specific movie. We also need to
-	// use the errors.Is() function to check if it returns a data.ErrRecordNotFound
-	// error, in which case we send a 404 Not Found response to the client.
-	student, err := app.models.Students.Get(id)
-	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
-		return
-	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"student": student}, nil)
+	// The student has already been fetched by the requireStudentInScope middleware,
+	// which sends the 404 Not Found response if it doesn't exist.
+	student := app.contextGetStudent(r)
+	err := app.writeJSON(w, http.StatusOK, envelope{"student": student}, http.Header{"Etag": {etag(student.Version)}})
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
 func (app *application) updateStudentHandler(w http.ResponseWriter, r *http.Request) {
-	// Extract the movie ID from the URL.
-	id, err := app.readIDParam(r)
+	// Take the existing student record that requireStudentInScope fetched.
+	student := app.contextGetStudent(r)
+	// If the client told us which version of the record it edited, make sure that is
+	// still the current version before going any further.
+	match, err := app.versionMatches(r, student.Version)
 	if err != nil {
-		app.notFoundResponse(w, r)
+		app.badRequestResponse(w, r, err)
 		return
 	}
-	// Fetch the existing movie record from the database, sending a 404 Not Found
-	// response to the client if we couldn't find a matching record.
-	student, err := app.models.Students.Get(id)
-	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+	if !match {
+		app.editConflictResponse(w, r)
 		return
 	}
 	// Declare an input struct to hold the expected data from the client.
@@ -126,31 +112,112 @@ func (app *application) updateStudentHandler(w http.ResponseWriter, r *http.Requ
 	// response if any checks fail.
 	v := validator.New()
 	if data.ValidateStudent(v, student); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+	// Make sure a user limited to their own faculty isn't moving the student into
+	// another one.
+	if !app.facultyInScope(r, student.FacultyId) {
+		app.notPermittedResponse(w, r)
 		return
 	}
 	// Pass the updated movie record to our new Update() method.
 	err = app.models.Students.Update(student)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 	// Write the updated movie record in a JSON response.
-	err = app.writeJSON(w, http.StatusOK, envelope{"student": student}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"student": student}, http.Header{"Etag": {etag(student.Version)}})
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
-func (app *application) deleteStudentHandler(w http.ResponseWriter, r *http.Request) {
-	// Extract the movie ID from the URL.
-	id, err := app.readIDParam(r)
+
+func (app *application) patchStudentHandler(w http.ResponseWriter, r *http.Request) {
+	student := app.contextGetStudent(r)
+	match, err := app.versionMatches(r, student.Version)
 	if err != nil {
-		app.notFoundResponse(w, r)
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if !match {
+		app.editConflictResponse(w, r)
 		return
 	}
+	// As in patchFacultyHandler, pointer fields let us leave out anything the client
+	// didn't send.
+	var input struct {
+		Name      *string `json:"name"`
+		Surname   *string `json:"surname"`
+		StudyYear *int32  `json:"study_year"`
+		Age       *int32  `json:"age"`
+		FacultyId *int32  `json:"faculty_id"`
+		Runtime   *int32  `json:"runtime"`
+	}
+	err = app.readPatchJSON(w, r, &input)
+	if err != nil {
+		switch {
+		case errors.Is(err, errUnsupportedMediaType):
+			app.unsupportedMediaTypeResponse(w, r)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	if input.Name != nil {
+		student.Name = *input.Name
+	}
+	if input.Surname != nil {
+		student.Surname = *input.Surname
+	}
+	if input.StudyYear != nil {
+		student.StudyYear = *input.StudyYear
+	}
+	if input.Age != nil {
+		student.Age = *input.Age
+	}
+	if input.FacultyId != nil {
+		student.FacultyId = *input.FacultyId
+	}
+	if input.Runtime != nil {
+		student.Runtime = *input.Runtime
+	}
+	v := validator.New()
+	if data.ValidateStudent(v, student); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+	if !app.facultyInScope(r, student.FacultyId) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+	err = app.models.Students.Update(student)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"student": student}, http.Header{"Etag": {etag(student.Version)}})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteStudentHandler(w http.ResponseWriter, r *http.Request) {
+	student := app.contextGetStudent(r)
 	// Delete the movie from the database, sending a 404 Not Found response to the
 	// client if there isn't a matching record.
-	err = app.models.Students.Delete(id)
+	err := app.models.Students.Delete(student.ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -161,7 +228,7 @@ func (app *application) deleteStudentHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	// Return a 200 OK status code along with a success message.
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "student successfully deleted"}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": app.translate(r, "student successfully deleted")}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -198,9 +265,14 @@ func (app *application) listStudentsHandler(w http.ResponseWriter, r *http.Reque
 	input.Filters.SortSafelist = []string{"id", "name", "surname", "runtime", "study_year", "age", "faculty_id", "-id", "-name", "-surname", "-runtime", "-study_year", "-age", "-faculty_id"}
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
+	// If the user is limited to their own faculty, only ever list its students,
+	// whatever faculty_id the client asked for.
+	if facultyID, scoped := app.contextGetFacultyScope(r); scoped {
+		input.FacultyId = int(facultyID)
+	}
 	// Call the GetAll() method to retrieve the movies, passing in the various filter
 	// parameters.
 	students, metadata, err := app.models.Students.GetAll(input.Name, input.Surname, input.Filters, input.StudyYear, input.Age, input.FacultyId)
@@ -25,7 +25,7 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	data.ValidateEmail(v, input.Email)
 	data.ValidatePasswordPlaintext(v, input.Password)
 	if !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 	// Lookup the user record based on the email address. If no matching user was
@@ -55,7 +55,7 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	}
 	// Otherwise, if the password is correct, we generate a new token with a 24-hour
 	// expiry time and the scope 'authentication'.
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	token, err := app.models.Tokens.NewSession(user.ID, 24*time.Hour, r.UserAgent())
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -67,3 +67,138 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+	// We send the same response whether or not a matching, activated account exists,
+	// so that this endpoint can't be used to find out which emails are registered.
+	env := envelope{"message": app.translate(r, "if an activated account exists for this email address, you will receive an email containing password reset instructions")}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if user.Activated {
+		// Queue the password reset email in the outbox. The worker creates the token,
+		// with a 45-minute expiry time, when it sends the email.
+		err = app.models.Outbox.Enqueue(&data.Email{
+			Recipient:  user.Email,
+			Template:   "token_password_reset.tmpl",
+			Locale:     app.emailLocale(r, user),
+			RequestID:  app.contextGetRequestID(r),
+			UserID:     &user.ID,
+			TokenScope: data.ScopePasswordReset,
+		})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+	// As with password resets, the response is the same whether the account doesn't
+	// exist, is already activated, or has just been sent a new token.
+	env := envelope{"message": app.translate(r, "if an unactivated account exists for this email address, you will receive an email containing activation instructions")}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			err = app.writeJSON(w, http.StatusAccepted, env, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !user.Activated {
+		// Queue an email with a fresh activation token in the outbox. The worker
+		// creates the token, with the same 3-day expiry as the one sent on
+		// registration, when it sends the email.
+		err = app.models.Outbox.Enqueue(&data.Email{
+			Recipient:  user.Email,
+			Template:   "token_activation.tmpl",
+			Locale:     app.emailLocale(r, user),
+			RequestID:  app.contextGetRequestID(r),
+			UserID:     &user.ID,
+			TokenScope: data.ScopeActivation,
+		})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// Revoke only the token that this request was authenticated with.
+	err := app.models.Tokens.DeleteForPlaintext(data.ScopeAuthentication, app.contextGetToken(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": app.translate(r, "you have been logged out")}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteAllAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	err := app.models.Tokens.DeleteAllForUser(data.ScopeAuthentication, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": app.translate(r, "you have been logged out of all sessions")}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
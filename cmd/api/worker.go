@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"arnur.second.try/internal/data"
+	"arnur.second.try/internal/jsonlog"
+)
+
+// outboxLease is how long an email stays claimed by a worker. It has to be longer than
+// it can take to send an email, otherwise a slow delivery could be picked up a second
+// time by another worker.
+const outboxLease = time.Minute
+
+// outboxMaxBackoff caps the exponential backoff between two attempts.
+const outboxMaxBackoff = time.Hour
+
+// emailTokens describes the token created for an email with the given token scope when
+// it is delivered: the key it is passed to the template under, and how long it is
+// valid for.
+var emailTokens = map[string]struct {
+	key string
+	ttl time.Duration
+}{
+	data.ScopeActivation:    {"activationToken", 3 * 24 * time.Hour},
+	data.ScopePasswordReset: {"passwordResetToken", 45 * time.Minute},
+}
+
+// startOutboxWorkers launches the configured number of workers which deliver the
+// emails waiting in the outbox. The workers are tracked by app.wg and stop once ctx is
+// cancelled, after finishing the email they are currently sending.
+func (app *application) startOutboxWorkers(ctx context.Context) {
+	for i := 0; i < app.config.outbox.workers; i++ {
+		app.wg.Add(1)
+		go func() {
+			defer app.wg.Done()
+			app.runOutboxWorker(ctx)
+		}()
+	}
+}
+
+func (app *application) runOutboxWorker(ctx context.Context) {
+	ticker := time.NewTicker(app.config.outbox.pollInterval)
+	defer ticker.Stop()
+	for {
+		// Keep delivering emails for as long as there are some due, and only then wait
+		// for the next tick.
+		for ctx.Err() == nil {
+			delivered, err := app.deliverNextEmail()
+			if err != nil {
+				app.logger.PrintError(err, nil)
+				break
+			}
+			if !delivered {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliverNextEmail claims one due email from the outbox and tries to send it. It
+// returns false if there was nothing to send. A failed delivery is not an error here:
+// the email is rescheduled with an exponential backoff, or moved to the dead-letter
+// state once it has used up all of its attempts.
+func (app *application) deliverNextEmail() (delivered bool, err error) {
+	email, err := app.models.Outbox.Claim(outboxLease)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	// The token is only created now, so that it is never stored in the outbox and is
+	// always fresh, however long the email has been waiting.
+	templateData, token, err := app.emailTemplateData(email)
+	if err != nil {
+		return false, err
+	}
+
+	// A panic in the mailer (from a broken template, for example) must not take the
+	// worker down with it, so treat it like any other failed delivery.
+	sendErr := func() (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("%v", p)
+			}
+		}()
+		return app.mailer.Send(email.Recipient, email.Template, email.Locale, templateData)
+	}()
+
+	logger := app.logger.With(jsonlog.Properties{
+		"email_id":   email.ID,
+		"request_id": email.RequestID,
+		"template":   email.Template,
+		"attempts":   email.Attempts,
+	})
+	if sendErr == nil {
+		app.metrics.mailSends.Inc("success")
+		logger.PrintDebug("email sent", nil)
+		return true, app.models.Outbox.MarkSent(email.ID)
+	}
+
+	app.metrics.mailSends.Inc("failure")
+	// The token never reached the user, so there is no reason to keep it valid. The
+	// next attempt creates a new one.
+	if token != nil {
+		err = app.models.Tokens.DeleteForPlaintext(token.Scope, token.Plaintext)
+		if err != nil {
+			logger.PrintError(err, nil)
+		}
+	}
+	dead := email.Attempts >= app.config.outbox.maxAttempts
+	retryIn := app.outboxBackoff(email.Attempts)
+	// A failure which will be retried is only a warning. Once the email is moved to
+	// the dead-letter state somebody has to look at it, so that is an error.
+	if dead {
+		logger.PrintError(sendErr, jsonlog.Properties{"dead": true})
+	} else {
+		logger.PrintWarn(sendErr.Error(), jsonlog.Properties{"retry_in": retryIn})
+	}
+	return true, app.models.Outbox.MarkFailed(email.ID, sendErr.Error(), retryIn, dead)
+}
+
+// emailTemplateData returns the data to render an email with. If the email needs a
+// token, a new one is created for the user and added to a copy of the email's data.
+func (app *application) emailTemplateData(email *data.Email) (map[string]interface{}, *data.Token, error) {
+	if email.TokenScope == "" {
+		return email.Data, nil, nil
+	}
+	spec, ok := emailTokens[email.TokenScope]
+	if !ok {
+		return nil, nil, fmt.Errorf("email %d has unknown token scope %q", email.ID, email.TokenScope)
+	}
+	if email.UserID == nil {
+		return nil, nil, fmt.Errorf("email %d needs a token but has no user", email.ID)
+	}
+	token, err := app.models.Tokens.New(*email.UserID, spec.ttl, email.TokenScope)
+	if err != nil {
+		return nil, nil, err
+	}
+	templateData := make(map[string]interface{}, len(email.Data)+1)
+	for key, value := range email.Data {
+		templateData[key] = value
+	}
+	templateData[spec.key] = token.Plaintext
+	return templateData, token, nil
+}
+
+// outboxBackoff returns how long to wait before the next attempt: the configured base
+// delay after the first failure, doubling with every further failure.
+func (app *application) outboxBackoff(attempts int) time.Duration {
+	backoff := app.config.outbox.backoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= outboxMaxBackoff {
+			return outboxMaxBackoff
+		}
+	}
+	return backoff
+}
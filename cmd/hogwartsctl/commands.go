@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"arnur.second.try/internal/data"
+	"arnur.second.try/internal/validator"
+)
+
+// newFlagSet returns a FlagSet for a single command which reports errors instead of
+// exiting, so that they're printed in the same way as every other error.
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet("hogwartsctl "+name, flag.ContinueOnError)
+}
+
+// splitCodes turns a comma-separated list of permission codes into a slice, dropping
+// any empty entries.
+func splitCodes(list string) []string {
+	codes := []string{}
+	for _, code := range strings.Split(list, ",") {
+		if code = strings.TrimSpace(code); code != "" {
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
+
+// passwordEnv is the environment variable that create-user and reset-password read the
+// password from. Passwords are never taken as flags, because the arguments of a command
+// can be seen by every user through ps and end up in the shell history.
+const passwordEnv = "HOGWARTS_PASSWORD"
+
+// The readPassword() method returns the password from the HOGWARTS_PASSWORD environment
+// variable if it is set, and otherwise reads it from the first line of stdin.
+func (app *application) readPassword() (string, error) {
+	if password, ok := os.LookupEnv(passwordEnv); ok {
+		return password, nil
+	}
+	line, err := bufio.NewReader(app.in).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("reading password from stdin: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// validationError converts the errors collected by a Validator into a single error,
+// with the keys in a stable order.
+func validationError(v *validator.Validator) error {
+	keys := make([]string, 0, len(v.Errors))
+	for key := range v.Errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	messages := make([]string, 0, len(keys))
+	for _, key := range keys {
+		messages = append(messages, fmt.Sprintf("%s %s", key, v.Errors[key]))
+	}
+	return errors.New(strings.Join(messages, "; "))
+}
+
+// The lookupUser() method fetches a user by email address, returning a friendlier
+// error if no such user exists.
+func (app *application) lookupUser(email string) (*data.User, error) {
+	if email == "" {
+		return nil, errors.New("-email must be provided")
+	}
+	user, err := app.models.Users.GetByEmail(email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, fmt.Errorf("no user with email %q", email)
+		default:
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+func (app *application) printUser(user *data.User, permissions data.Permissions) error {
+	value := struct {
+		*data.User
+		Permissions data.Permissions `json:"permissions"`
+	}{user, permissions}
+	faculty := "-"
+	if user.FacultyID != nil {
+		faculty = strconv.FormatInt(*user.FacultyID, 10)
+	}
+	rows := [][]string{{
+		strconv.FormatInt(user.ID, 10),
+		user.Name,
+		user.Email,
+		strconv.FormatBool(user.Activated),
+		faculty,
+		strings.Join(permissions, ","),
+	}}
+	return app.print(envelope{"user": value}, []string{"ID", "NAME", "EMAIL", "ACTIVATED", "FACULTY", "PERMISSIONS"}, rows)
+}
+
+func (app *application) createUserCommand(args []string) error {
+	fs := newFlagSet("create-user")
+	name := fs.String("name", "", "User name")
+	email := fs.String("email", "", "User email address")
+	activated := fs.Bool("activated", false, "Create the user already activated")
+	facultyID := fs.Int64("faculty", 0, "ID of the user's own faculty (0 for none)")
+	roles := fs.String("roles", "student", "Comma-separated roles to assign")
+	codes := fs.String("permissions", "", "Comma-separated permission codes to grant")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	user := &data.User{
+		Name:      *name,
+		Email:     *email,
+		Activated: *activated,
+	}
+	if *facultyID > 0 {
+		user.FacultyID = facultyID
+	}
+	password, err := app.readPassword()
+	if err != nil {
+		return err
+	}
+	err = user.Password.Set(password)
+	if err != nil {
+		return err
+	}
+	v := validator.New()
+	if data.ValidateUser(v, user); !v.Valid() {
+		return validationError(v)
+	}
+
+	err = app.models.Users.Create(user, splitCodes(*roles), splitCodes(*codes))
+	if err != nil {
+		return err
+	}
+
+	granted, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return err
+	}
+	return app.printUser(user, granted)
+}
+
+func (app *application) activateCommand(args []string) error {
+	fs := newFlagSet("activate")
+	email := fs.String("email", "", "User email address")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	user, err := app.lookupUser(*email)
+	if err != nil {
+		return err
+	}
+	if !user.Activated {
+		user.Activated = true
+		err = app.models.Users.Update(user)
+		if err != nil {
+			return err
+		}
+		// Any outstanding activation tokens are no longer of any use.
+		err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return err
+	}
+	return app.printUser(user, permissions)
+}
+
+// The set-faculty command links a user to their own faculty, which limits what they
+// can do with scoped permissions such as students:write:own_faculty. Passing
+// -faculty 0 removes the link again.
+func (app *application) setFacultyCommand(args []string) error {
+	fs := newFlagSet("set-faculty")
+	email := fs.String("email", "", "User email address")
+	facultyID := fs.Int64("faculty", 0, "ID of the user's own faculty (0 to clear it)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	user, err := app.lookupUser(*email)
+	if err != nil {
+		return err
+	}
+	user.FacultyID = nil
+	if *facultyID > 0 {
+		// Check the faculty exists, to give a clearer error than the foreign key.
+		_, err = app.models.Faculties.Get(*facultyID)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return fmt.Errorf("no faculty with ID %d", *facultyID)
+			}
+			return err
+		}
+		user.FacultyID = facultyID
+	}
+	err = app.models.Users.Update(user)
+	if err != nil {
+		return err
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return err
+	}
+	return app.printUser(user, permissions)
+}
+
+// The grant and revoke commands are recorded in the permissions audit log without an
+// actor, since they weren't made by a user through the API.
+func (app *application) grantCommand(args []string) error {
+	return app.changePermissions("grant", args, func(userID int64, codes ...string) error {
+		return app.models.Permissions.GrantForUser(0, userID, codes...)
+	})
+}
+
+func (app *application) revokeCommand(args []string) error {
+	return app.changePermissions("revoke", args, func(userID int64, codes ...string) error {
+		return app.models.Permissions.RevokeForUser(0, userID, codes...)
+	})
+}
+
+// changePermissions holds the logic shared by the grant and revoke commands.
+func (app *application) changePermissions(name string, args []string, change func(int64, ...string) error) error {
+	fs := newFlagSet(name)
+	email := fs.String("email", "", "User email address")
+	codes := fs.String("permission", "", "Comma-separated permission codes")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	permissions := splitCodes(*codes)
+	if len(permissions) == 0 {
+		return errors.New("-permission must be provided")
+	}
+
+	user, err := app.lookupUser(*email)
+	if err != nil {
+		return err
+	}
+
+	// Check the codes against the permissions table first, because unknown codes
+	// would otherwise be silently skipped.
+	known, err := app.models.Permissions.GetAll()
+	if err != nil {
+		return err
+	}
+	for _, code := range permissions {
+		if !validator.In(code, known...) {
+			return fmt.Errorf("unknown permission code %q", code)
+		}
+	}
+
+	err = change(user.ID, permissions...)
+	if err != nil {
+		return err
+	}
+
+	current, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return err
+	}
+	return app.printUser(user, current)
+}
+
+func (app *application) assignRoleCommand(args []string) error {
+	return app.changeRoles("assign-role", args, func(userID int64, names ...string) error {
+		return app.models.Roles.AddForUser(0, userID, names...)
+	})
+}
+
+func (app *application) unassignRoleCommand(args []string) error {
+	return app.changeRoles("unassign-role", args, func(userID int64, names ...string) error {
+		return app.models.Roles.RemoveForUser(0, userID, names...)
+	})
+}
+
+// changeRoles holds the logic shared by the assign-role and unassign-role commands.
+func (app *application) changeRoles(name string, args []string, change func(int64, ...string) error) error {
+	fs := newFlagSet(name)
+	email := fs.String("email", "", "User email address")
+	list := fs.String("role", "", "Comma-separated role names")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	roles := splitCodes(*list)
+	if len(roles) == 0 {
+		return errors.New("-role must be provided")
+	}
+
+	user, err := app.lookupUser(*email)
+	if err != nil {
+		return err
+	}
+	known, err := app.models.Roles.Names()
+	if err != nil {
+		return err
+	}
+	for _, role := range roles {
+		if !validator.In(role, known...) {
+			return fmt.Errorf("unknown role %q", role)
+		}
+	}
+
+	err = change(user.ID, roles...)
+	if err != nil {
+		return err
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return err
+	}
+	return app.printUser(user, permissions)
+}
+
+func (app *application) listPermissionsCommand(args []string) error {
+	fs := newFlagSet("list-permissions")
+	email := fs.String("email", "", "User email address (lists every permission code if omitted)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *email == "" {
+		permissions, err := app.models.Permissions.GetAll()
+		if err != nil {
+			return err
+		}
+		rows := make([][]string, 0, len(permissions))
+		for _, code := range permissions {
+			rows = append(rows, []string{code})
+		}
+		return app.print(envelope{"permissions": permissions}, []string{"CODE"}, rows)
+	}
+
+	user, err := app.lookupUser(*email)
+	if err != nil {
+		return err
+	}
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return err
+	}
+	return app.printUser(user, permissions)
+}
+
+func (app *application) resetPasswordCommand(args []string) error {
+	fs := newFlagSet("reset-password")
+	email := fs.String("email", "", "User email address")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	password, err := app.readPassword()
+	if err != nil {
+		return err
+	}
+	v := validator.New()
+	if data.ValidatePasswordPlaintext(v, password); !v.Valid() {
+		return validationError(v)
+	}
+
+	user, err := app.lookupUser(*email)
+	if err != nil {
+		return err
+	}
+	err = user.Password.Set(password)
+	if err != nil {
+		return err
+	}
+	err = app.models.Users.Update(user)
+	if err != nil {
+		return err
+	}
+	// Log the user out everywhere, since the old password may have been compromised.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeAuthentication, user.ID)
+	if err != nil {
+		return err
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return err
+	}
+	return app.printUser(user, permissions)
+}
+
+func (app *application) expireTokensCommand(args []string) error {
+	fs := newFlagSet("expire-tokens")
+	email := fs.String("email", "", "User email address")
+	scope := fs.String("scope", "", "Only delete tokens with this scope (activation|authentication|password-reset)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	scopes := []string{data.ScopeActivation, data.ScopeAuthentication, data.ScopePasswordReset}
+	if *scope != "" {
+		if !validator.In(*scope, scopes...) {
+			return fmt.Errorf("invalid -scope %q", *scope)
+		}
+		scopes = []string{*scope}
+	}
+
+	user, err := app.lookupUser(*email)
+	if err != nil {
+		return err
+	}
+	for _, s := range scopes {
+		err = app.models.Tokens.DeleteAllForUser(s, user.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	result := map[string]interface{}{
+		"user_id":    user.ID,
+		"scopes":     scopes,
+		"expired_at": time.Now().UTC().Format(time.RFC3339),
+	}
+	rows := [][]string{{strconv.FormatInt(user.ID, 10), strings.Join(scopes, ","), result["expired_at"].(string)}}
+	return app.print(envelope{"expired_tokens": result}, []string{"USER ID", "SCOPES", "EXPIRED AT"}, rows)
+}
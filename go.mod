@@ -2,11 +2,12 @@ module arnur.second.try
 
 go 1.21.6
 
-require github.com/julienschmidt/httprouter v1.3.0
-
 require (
-	github.com/go-mail/mail/v2 v2.3.0 // indirect
-	github.com/lib/pq v1.10.2 // indirect
-	golang.org/x/crypto v0.22.0 // indirect
-	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
+	github.com/go-mail/mail/v2 v2.3.0
+	github.com/julienschmidt/httprouter v1.3.0
+	github.com/lib/pq v1.10.2
+	golang.org/x/crypto v0.22.0
+	golang.org/x/time v0.5.0
 )
+
+require gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
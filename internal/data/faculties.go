@@ -23,15 +23,15 @@ type Faculty struct {
 
 func ValidateFaculty(v *validator.Validator, faculty *Faculty) {
 
-	v.Check(faculty.Title != "", "title", "must be provided")
-	v.Check(len(faculty.Title) <= 500, "title", "must not be more than 500 bytes long")
-	v.Check(faculty.Year != 0, "year", "must be provided")
-	v.Check(faculty.Year >= 1, "year", "must be greater than 1")
-	v.Check(faculty.Year <= int32(time.Now().Year()), "year", "must not be in the future")
-	v.Check(faculty.Runtime != 0, "runtime", "must be provided")
-	v.Check(faculty.Runtime > 0, "runtime", "must be a positive integer")
-	v.Check(faculty.Founder != "", "founder", "must be provided")
-	v.Check(len(faculty.Founder) <= 500, "founder", "must not be more than 500 bytes long")
+	v.CheckCode(faculty.Title != "", "title", validator.CodeRequired, "must be provided", nil)
+	v.CheckCode(len(faculty.Title) <= 500, "title", validator.CodeMaxLength, "must not be more than 500 bytes long", validator.Params{"max": 500})
+	v.CheckCode(faculty.Year != 0, "year", validator.CodeRequired, "must be provided", nil)
+	v.CheckCode(faculty.Year >= 1, "year", validator.CodeOutOfRange, "must be greater than 1", validator.Params{"min": 1})
+	v.CheckCode(faculty.Year <= int32(time.Now().Year()), "year", validator.CodeOutOfRange, "must not be in the future", validator.Params{"max": time.Now().Year()})
+	v.CheckCode(faculty.Runtime != 0, "runtime", validator.CodeRequired, "must be provided", nil)
+	v.CheckCode(faculty.Runtime > 0, "runtime", validator.CodeOutOfRange, "must be a positive integer", validator.Params{"min": 1})
+	v.CheckCode(faculty.Founder != "", "founder", validator.CodeRequired, "must be provided", nil)
+	v.CheckCode(len(faculty.Founder) <= 500, "founder", validator.CodeMaxLength, "must not be more than 500 bytes long", validator.Params{"max": 500})
 }
 
 type FacultyModel struct {
@@ -82,14 +82,16 @@ func (f FacultyModel) Get(id int64) (*Faculty, error) {
 	return &faculty, nil
 }
 
-// Add a placeholder method for updating a specific record in the movies table.
+// Update a specific record in the faculties table. The WHERE clause also checks the
+// version number, so if the record has been changed since it was read we return an
+// ErrEditConflict error instead of silently overwriting the other change.
 func (f FacultyModel) Update(faculty *Faculty) error {
 	// Declare the SQL query for updating the record and returning the new version
 	// number.
 	query := `
 UPDATE faculties
 SET title = $1, year = $2, runtime = $3, founder = $4, version = version + 1
-WHERE id = $5
+WHERE id = $5 AND version = $6
 RETURNING version`
 	// Create an args slice containing the values for the placeholder parameters.
 	args := []interface{}{
@@ -98,11 +100,22 @@ RETURNING version`
 		faculty.Runtime,
 		faculty.Founder,
 		faculty.ID,
+		faculty.Version,
 	}
-	// Use the QueryRow() method to execute the query, passing in the args slice as a
-	// variadic parameter and scanning the new version value into the movie struct.
-	return f.DB.QueryRow(query, args...).Scan(&faculty.Version)
-
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	// If no matching row could be found, we know the version has changed (or the
+	// record has been deleted) since we fetched it.
+	err := f.DB.QueryRowContext(ctx, query, args...).Scan(&faculty.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
 }
 
 // Add a placeholder method for deleting a specific record from the movies table.
@@ -137,26 +150,30 @@ func (f FacultyModel) Delete(id int64) error {
 
 }
 
-func (f FacultyModel) GetAll(founder string, title string, year int, filters Filters) ([]*Faculty, error) {
-
+func (f FacultyModel) GetAll(founder string, title string, year int, filters Filters) ([]*Faculty, Metadata, error) {
+	// Use the count(*) OVER() window function so that every row also carries the total
+	// number of records matching the filters, which we need for the pagination
+	// metadata.
 	query := fmt.Sprintf(`
-	SELECT id, founder, title, year, runtime,version
+	SELECT count(*) OVER(), id, created_at, founder, title, year, runtime, version
 	FROM faculties
-	WHERE  (to_tsvector('simple', founder) @@ plainto_tsquery('simple', $1) OR $1 = '')
-	AND  (to_tsvector('simple', title) @@ plainto_tsquery('simple', $2) OR $2 = '')
+	WHERE (to_tsvector('simple', founder) @@ plainto_tsquery('simple', $1) OR $1 = '')
+	AND (to_tsvector('simple', title) @@ plainto_tsquery('simple', $2) OR $2 = '')
+	AND (year = $3 OR $3 = 0)
 	ORDER BY %s %s, id ASC
-	LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+	LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := f.DB.QueryContext(ctx, query, founder, title, filters.limit(), filters.offset())
+	rows, err := f.DB.QueryContext(ctx, query, founder, title, year, filters.limit(), filters.offset())
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 
 	defer rows.Close()
 
+	totalRecords := 0
 	faculties := []*Faculty{}
 
 	for rows.Next() {
@@ -164,7 +181,9 @@ func (f FacultyModel) GetAll(founder string, title string, year int, filters Fil
 		var faculty Faculty
 
 		err := rows.Scan(
+			&totalRecords,
 			&faculty.ID,
+			&faculty.CreatedAt,
 			&faculty.Founder,
 			&faculty.Title,
 			&faculty.Year,
@@ -172,15 +191,17 @@ func (f FacultyModel) GetAll(founder string, title string, year int, filters Fil
 			&faculty.Version,
 		)
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
 
 		faculties = append(faculties, &faculty)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 
-	return faculties, nil
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return faculties, metadata, nil
 }
@@ -37,12 +37,12 @@ func (f Filters) sortDirection() string {
 
 func ValidateFilters(v *validator.Validator, f Filters) {
 	// Check that the page and page_size parameters contain sensible values.
-	v.Check(f.Page > 0, "page", "must be greater than zero")
-	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
-	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
-	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
+	v.CheckCode(f.Page > 0, "page", validator.CodeOutOfRange, "must be greater than zero", validator.Params{"min": 1})
+	v.CheckCode(f.Page <= 10_000_000, "page", validator.CodeOutOfRange, "must be a maximum of 10 million", validator.Params{"max": 10_000_000})
+	v.CheckCode(f.PageSize > 0, "page_size", validator.CodeOutOfRange, "must be greater than zero", validator.Params{"min": 1})
+	v.CheckCode(f.PageSize <= 100, "page_size", validator.CodeOutOfRange, "must be a maximum of 100", validator.Params{"max": 100})
 	// Check that the sort parameter matches a value in the safelist.
-	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	v.CheckCode(validator.In(f.Sort, f.SortSafelist...), "sort", validator.CodeNotAllowed, "invalid sort value", validator.Params{"allowed": f.SortSafelist})
 }
 
 func (f Filters) limit() int {
@@ -1,6 +1,7 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 )
@@ -19,6 +20,8 @@ type Models struct {
 	Users       UserModel
 	Tokens      TokenModel
 	Permissions PermissionModel
+	Roles       RoleModel
+	Outbox      OutboxModel
 }
 
 // For ease of use, we also add a New() method which returns a Models struct containing
@@ -30,5 +33,15 @@ func NewModels(db *sql.DB) Models {
 		Users:       UserModel{DB: db},
 		Tokens:      TokenModel{DB: db},
 		Permissions: PermissionModel{DB: db},
+		Roles:       RoleModel{DB: db},
+		Outbox:      OutboxModel{DB: db},
 	}
 }
+
+// querier is satisfied by both *sql.DB and *sql.Tx. Helpers which take a querier can
+// be used on their own, or as one step of a larger transaction.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
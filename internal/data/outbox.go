@@ -0,0 +1,232 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"arnur.second.try/internal/validator"
+)
+
+// The states an email in the outbox moves through. Every email starts out pending,
+// and ends up either sent or, once it has failed too many times, dead. Dead emails
+// stay in the table until an admin requeues them.
+const (
+	EmailPending = "pending"
+	EmailSent    = "sent"
+	EmailDead    = "dead"
+)
+
+var EmailStatuses = []string{EmailPending, EmailSent, EmailDead}
+
+// Email is a message waiting in (or already delivered from) the outbox. Data holds
+// the dynamic data for the template, is never included in JSON responses and is
+// cleared as soon as the email is sent. Plaintext tokens are never stored: if the
+// email needs one, TokenScope is set and a fresh token for UserID is created when the
+// email is delivered, so that a retried or requeued email never carries an expired
+// token.
+type Email struct {
+	ID            int64                  `json:"id"`
+	CreatedAt     time.Time              `json:"created_at"`
+	Recipient     string                 `json:"recipient"`
+	Template      string                 `json:"template"`
+	Locale        string                 `json:"locale,omitempty"`
+	RequestID     string                 `json:"request_id,omitempty"` // The request which queued the email
+	UserID        *int64                 `json:"user_id,omitempty"`
+	TokenScope    string                 `json:"token_scope,omitempty"`
+	Data          map[string]interface{} `json:"-"`
+	Status        string                 `json:"status"`
+	Attempts      int                    `json:"attempts"`
+	LastError     string                 `json:"last_error,omitempty"`
+	NextAttemptAt time.Time              `json:"next_attempt_at"`
+	SentAt        *time.Time             `json:"sent_at,omitempty"`
+}
+
+func ValidateEmailStatus(v *validator.Validator, status string) {
+	v.CheckCode(status == "" || validator.In(status, EmailStatuses...), "status", validator.CodeNotAllowed, "must be one of pending, sent or dead", validator.Params{"allowed": EmailStatuses})
+}
+
+// Define the OutboxModel type.
+type OutboxModel struct {
+	DB *sql.DB
+}
+
+const emailColumns = `id, created_at, recipient, template, locale, request_id, user_id, token_scope, data, status, attempts, last_error, next_attempt_at, sent_at`
+
+// insertEmail adds a pending email to the outbox. It takes a querier so that the email
+// can be written in the same transaction as the data it is about, see
+// UserModel.Register().
+func insertEmail(ctx context.Context, q querier, email *Email) error {
+	js, err := json.Marshal(email.Data)
+	if err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO email_outbox (recipient, template, locale, request_id, user_id, token_scope, data)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err = q.ExecContext(ctx, query, email.Recipient, email.Template, email.Locale, email.RequestID, email.UserID, email.TokenScope, js)
+	return err
+}
+
+// The Enqueue() method adds an email to the outbox. Only the Recipient, Template,
+// Locale, RequestID, UserID, TokenScope and Data fields are used. It will be picked up by one of the
+// outbox workers, which render it in the given locale and also take care of retrying
+// it if delivery fails.
+func (m OutboxModel) Enqueue(email *Email) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return insertEmail(ctx, m.DB, email)
+}
+
+// The Claim() method picks the oldest pending email which is due, counts the attempt
+// and pushes its next_attempt_at forward by lease. FOR UPDATE SKIP LOCKED lets several
+// workers (and several API instances) claim emails at once without handing the same
+// email to two of them. If a worker dies while sending, the email simply becomes due
+// again when the lease runs out. ErrRecordNotFound means nothing is due right now.
+func (m OutboxModel) Claim(lease time.Duration) (*Email, error) {
+	query := `
+	UPDATE email_outbox
+	SET attempts = attempts + 1, next_attempt_at = NOW() + make_interval(secs => $1)
+	WHERE id = (
+		SELECT id FROM email_outbox
+		WHERE status = 'pending' AND next_attempt_at <= NOW()
+		ORDER BY next_attempt_at, id
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING ` + emailColumns
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return scanEmail(m.DB.QueryRowContext(ctx, query, lease.Seconds()))
+}
+
+// The MarkSent() method records a successful delivery and drops the template data.
+func (m OutboxModel) MarkSent(id int64) error {
+	query := `
+	UPDATE email_outbox
+	SET status = 'sent', sent_at = NOW(), data = NULL, last_error = ''
+	WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// The MarkFailed() method records a failed delivery. The email is retried after
+// retryIn, unless dead is true, in which case it is moved to the dead-letter state.
+func (m OutboxModel) MarkFailed(id int64, sendErr string, retryIn time.Duration, dead bool) error {
+	query := `
+	UPDATE email_outbox
+	SET status = CASE WHEN $4 THEN 'dead' ELSE 'pending' END,
+		last_error = $2,
+		next_attempt_at = NOW() + make_interval(secs => $3)
+	WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, id, sendErr, retryIn.Seconds(), dead)
+	return err
+}
+
+// Retrieve a single email from the outbox by its ID.
+func (m OutboxModel) Get(id int64) (*Email, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `SELECT ` + emailColumns + ` FROM email_outbox WHERE id = $1`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return scanEmail(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// The GetAll() method lists the emails in the outbox, optionally only those with the
+// given status or recipient.
+func (m OutboxModel) GetAll(status, recipient string, filters Filters) ([]*Email, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), %s
+	FROM email_outbox
+	WHERE (status = $1 OR $1 = '')
+	AND (recipient = $2 OR $2 = '')
+	ORDER BY %s %s, id ASC
+	LIMIT $3 OFFSET $4`, emailColumns, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, status, recipient, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	emails := []*Email{}
+	for rows.Next() {
+		var email Email
+		var js []byte
+		err := rows.Scan(&totalRecords, &email.ID, &email.CreatedAt, &email.Recipient, &email.Template, &email.Locale, &email.RequestID, &email.UserID, &email.TokenScope, &js,
+			&email.Status, &email.Attempts, &email.LastError, &email.NextAttemptAt, &email.SentAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		emails = append(emails, &email)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return emails, metadata, nil
+}
+
+// The Requeue() method gives a pending or dead email a fresh set of attempts and makes
+// it due straight away. Emails which have already been sent can't be requeued, and we
+// return ErrEditConflict if the email was sent in the meantime.
+func (m OutboxModel) Requeue(email *Email) error {
+	query := `
+	UPDATE email_outbox
+	SET status = 'pending', attempts = 0, next_attempt_at = NOW()
+	WHERE id = $1 AND status <> 'sent'
+	RETURNING status, attempts, next_attempt_at`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, email.ID).Scan(&email.Status, &email.Attempts, &email.NextAttemptAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func scanEmail(row *sql.Row) (*Email, error) {
+	var email Email
+	var js []byte
+	err := row.Scan(&email.ID, &email.CreatedAt, &email.Recipient, &email.Template, &email.Locale, &email.RequestID, &email.UserID, &email.TokenScope, &js,
+		&email.Status, &email.Attempts, &email.LastError, &email.NextAttemptAt, &email.SentAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	if js != nil {
+		// Decode numbers as json.Number so that IDs come back exactly as they went in,
+		// rather than as float64 values.
+		dec := json.NewDecoder(bytes.NewReader(js))
+		dec.UseNumber()
+		err = dec.Decode(&email.Data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &email, nil
+}
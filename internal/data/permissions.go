@@ -3,46 +3,99 @@ package data
 import (
 	"context"
 	"database/sql"
+	"strings"
 	"time"
 
+	"arnur.second.try/internal/validator"
 	"github.com/lib/pq"
 )
 
+// Define constants for the actions recorded in the permissions_audit table.
+const (
+	PermissionGranted = "grant"
+	PermissionRevoked = "revoke"
+)
+
 // Define a Permissions slice, which we will use to hold the permission codes (like
 // "movies:read" and "movies:write") for a single user.
 // "movies:read" and "movies:write") for a single user.
 type Permissions []string
 
-// Add a helper method to check whether the Permissions slice contains a specific
-// permission code.
+// Add a helper method to check whether the Permissions slice grants a specific
+// permission code. Besides exact matches, a wildcard code such as "students:*" grants
+// every code starting with "students:", and "*" on its own grants everything.
 func (p Permissions) Include(code string) bool {
 	for i := range p {
-		if code == p[i] {
+		if code == p[i] || matchWildcard(p[i], code) {
 			return true
 		}
 	}
 	return false
 }
 
+func matchWildcard(pattern, code string) bool {
+	if pattern == "*" {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasSuffix(prefix, ":") {
+		return strings.HasPrefix(code, prefix)
+	}
+	return false
+}
+
+// ValidatePermissionCodes checks that a list of codes sent by a client is non-empty,
+// has no duplicates and only contains codes which exist in the permissions table.
+func ValidatePermissionCodes(v *validator.Validator, codes []string, known Permissions) {
+	v.CheckCode(len(codes) > 0, "permissions", validator.CodeRequired, "must contain at least 1 permission", nil)
+	v.CheckCode(validator.Unique(codes), "permissions", validator.CodeDuplicate, "must not contain duplicate values", nil)
+	for _, code := range codes {
+		// Use an exact match here, so that a wildcard code in the table doesn't make
+		// every code look valid.
+		v.CheckCode(validator.In(code, known...), "permissions", validator.CodeNotAllowed, "must only contain known permission codes", validator.Params{"value": code})
+	}
+}
+
+// A PermissionChange is a single entry from the permissions_audit table, written when
+// a code is granted or revoked directly or when a role change gives or takes it away.
+// ActorID is nil when the change wasn't made by a user through the API, for example
+// when it was made with hogwartsctl or is the default role given at registration.
+type PermissionChange struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	ActorID   *int64    `json:"actor_id"`
+	UserID    int64     `json:"user_id"`
+	Code      string    `json:"permission"`
+	Action    string    `json:"action"`
+}
+
 // Define the PermissionModel type.
 type PermissionModel struct {
 	DB *sql.DB
 }
 
-// The GetAllForUser() method returns all permission codes for a specific user in a
-// Permissions slice. The code in this method should feel very familiar --- it uses the
-// standard pattern that we've already seen before for retrieving multiple data rows in
-// an SQL query.
+// The GetAllForUser() method returns the effective permission codes for a specific
+// user in a Permissions slice. These are the codes granted to the user directly plus
+// the codes granted through any of the user's roles.
 func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return getPermissionsForUser(ctx, m.DB, userID)
+}
+
+func getPermissionsForUser(ctx context.Context, q querier, userID int64) (Permissions, error) {
 	query := `
 SELECT permissions.code
 FROM permissions
 INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-INNER JOIN users ON users_permissions.user_id = users.id
-WHERE users.id = $1`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-	rows, err := m.DB.QueryContext(ctx, query, userID)
+WHERE users_permissions.user_id = $1
+UNION
+SELECT permissions.code
+FROM permissions
+INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+WHERE users_roles.user_id = $1
+ORDER BY code`
+	rows, err := q.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -71,3 +124,222 @@ func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
 	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
 	return err
 }
+
+// The RemoveForUser() method revokes the given permission codes from a specific user.
+// Codes which the user doesn't hold are ignored.
+func (m PermissionModel) RemoveForUser(userID int64, codes ...string) error {
+	query := `
+	DELETE FROM users_permissions
+	USING permissions
+	WHERE users_permissions.permission_id = permissions.id
+	AND users_permissions.user_id = $1
+	AND permissions.code = ANY($2)`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	return err
+}
+
+// The GetAll() method returns every permission code known to the application.
+func (m PermissionModel) GetAll() (Permissions, error) {
+	query := `
+	SELECT code
+	FROM permissions
+	ORDER BY code`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	permissions := Permissions{}
+	for rows.Next() {
+		var permission string
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// The GrantForUser() method grants the permission codes to a user and records each
+// newly granted code in the permissions_audit table, in a single transaction. Codes
+// which the user already holds are left alone and not recorded. An actorID of 0 is
+// stored as NULL.
+func (m PermissionModel) GrantForUser(actorID, userID int64, codes ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = grantPermissions(ctx, tx, actorID, userID, codes)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// The RevokeForUser() method is the audited counterpart of RemoveForUser().
+func (m PermissionModel) RevokeForUser(actorID, userID int64, codes ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = revokePermissions(ctx, tx, actorID, userID, codes)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// grantPermissions and revokePermissions are the steps behind GrantForUser() and
+// RevokeForUser(), so that they can also be part of a larger transaction.
+func grantPermissions(ctx context.Context, tx *sql.Tx, actorID, userID int64, codes []string) error {
+	query := `
+	INSERT INTO users_permissions
+	SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+	ON CONFLICT DO NOTHING
+	RETURNING (SELECT code FROM permissions WHERE permissions.id = permission_id)`
+	return changePermissions(ctx, tx, query, PermissionGranted, actorID, userID, codes)
+}
+
+func revokePermissions(ctx context.Context, tx *sql.Tx, actorID, userID int64, codes []string) error {
+	query := `
+	DELETE FROM users_permissions
+	USING permissions
+	WHERE users_permissions.permission_id = permissions.id
+	AND users_permissions.user_id = $1
+	AND permissions.code = ANY($2)
+	RETURNING permissions.code`
+	return changePermissions(ctx, tx, query, PermissionRevoked, actorID, userID, codes)
+}
+
+// changePermissions runs a grant or revoke query which returns the codes it actually
+// changed, and writes an audit entry for each of them.
+func changePermissions(ctx context.Context, tx *sql.Tx, query, action string, actorID, userID int64, codes []string) error {
+	rows, err := tx.QueryContext(ctx, query, userID, pq.Array(codes))
+	if err != nil {
+		return err
+	}
+	changed := []string{}
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			rows.Close()
+			return err
+		}
+		changed = append(changed, code)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	return auditPermissions(ctx, tx, action, actorID, userID, changed)
+}
+
+// auditPermissions writes one permissions_audit entry per code. An actorID of 0 is
+// stored as NULL.
+func auditPermissions(ctx context.Context, q querier, action string, actorID, userID int64, codes []string) error {
+	var actor interface{}
+	if actorID != 0 {
+		actor = actorID
+	}
+	query := `
+	INSERT INTO permissions_audit (actor_id, user_id, permission_code, action)
+	SELECT $1, $2, code, $3 FROM unnest($4::text[]) AS code`
+	_, err := q.ExecContext(ctx, query, actor, userID, action, pq.Array(codes))
+	return err
+}
+
+// The GetAuditForUser() method returns the history of permission changes for a user,
+// most recent first.
+func (m PermissionModel) GetAuditForUser(userID int64) ([]*PermissionChange, error) {
+	query := `
+	SELECT id, created_at, actor_id, user_id, permission_code, action
+	FROM permissions_audit
+	WHERE user_id = $1
+	ORDER BY id DESC`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	changes := []*PermissionChange{}
+	for rows.Next() {
+		var change PermissionChange
+		err := rows.Scan(
+			&change.ID,
+			&change.CreatedAt,
+			&change.ActorID,
+			&change.UserID,
+			&change.Code,
+			&change.Action,
+		)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, &change)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// The GetUsersForPermission() method returns every user who holds a specific
+// permission code, ordered by ID.
+func (m PermissionModel) GetUsersForPermission(code string) ([]*User, error) {
+	query := `
+	SELECT users.id, users.created_at, users.name, users.email, users.activated, users.faculty_id, users.locale, users.version
+	FROM users
+	INNER JOIN users_permissions ON users_permissions.user_id = users.id
+	INNER JOIN permissions ON users_permissions.permission_id = permissions.id
+	WHERE permissions.code = $1
+	ORDER BY users.id`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, code)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := []*User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Activated,
+			&user.FacultyID,
+			&user.Locale,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
@@ -0,0 +1,199 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"arnur.second.try/internal/validator"
+	"github.com/lib/pq"
+)
+
+// A Role bundles a set of permission codes under a name such as "prefect", so that
+// users can be given all of them at once.
+type Role struct {
+	ID          int64       `json:"id"`
+	Name        string      `json:"name"`
+	Permissions Permissions `json:"permissions"`
+}
+
+// ValidateRoleNames checks that a list of role names sent by a client is non-empty,
+// has no duplicates and only contains roles which exist.
+func ValidateRoleNames(v *validator.Validator, names []string, known []string) {
+	v.CheckCode(len(names) > 0, "roles", validator.CodeRequired, "must contain at least 1 role", nil)
+	v.CheckCode(validator.Unique(names), "roles", validator.CodeDuplicate, "must not contain duplicate values", nil)
+	for _, name := range names {
+		v.CheckCode(validator.In(name, known...), "roles", validator.CodeNotAllowed, "must only contain known roles", validator.Params{"value": name})
+	}
+}
+
+// Define the RoleModel type.
+type RoleModel struct {
+	DB *sql.DB
+}
+
+// The GetAll() method returns every role along with the permission codes it grants.
+func (m RoleModel) GetAll() ([]*Role, error) {
+	query := `
+	SELECT roles.id, roles.name,
+	COALESCE(array_agg(permissions.code ORDER BY permissions.code) FILTER (WHERE permissions.code IS NOT NULL), '{}')
+	FROM roles
+	LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+	LEFT JOIN permissions ON roles_permissions.permission_id = permissions.id
+	GROUP BY roles.id
+	ORDER BY roles.id`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	roles := []*Role{}
+	for rows.Next() {
+		var role Role
+		err := rows.Scan(&role.ID, &role.Name, pq.Array((*[]string)(&role.Permissions)))
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, &role)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// The Names() method returns the names of every role.
+func (m RoleModel) Names() ([]string, error) {
+	roles, err := m.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		names = append(names, role.Name)
+	}
+	return names, nil
+}
+
+// The GetAllForUser() method returns the names of the roles assigned to a user.
+func (m RoleModel) GetAllForUser(userID int64) ([]string, error) {
+	query := `
+	SELECT roles.name
+	FROM roles
+	INNER JOIN users_roles ON users_roles.role_id = roles.id
+	WHERE users_roles.user_id = $1
+	ORDER BY roles.name`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names := []string{}
+	for rows.Next() {
+		var name string
+		err := rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// The AddForUser() method assigns roles to a user. Roles that the user already has
+// are ignored. Every permission code that the user gains through the new roles is
+// recorded in the permissions_audit table, in the same transaction. An actorID of 0 is
+// stored as NULL.
+func (m RoleModel) AddForUser(actorID, userID int64, names ...string) error {
+	return m.changeForUser(addRolesForUser, actorID, userID, names)
+}
+
+// The RemoveForUser() method takes roles away from a user, and records every
+// permission code that the user loses as a result.
+func (m RoleModel) RemoveForUser(actorID, userID int64, names ...string) error {
+	return m.changeForUser(removeRolesForUser, actorID, userID, names)
+}
+
+// changeForUser runs a role change in a transaction, and audits the difference it made
+// to the user's effective permissions. Codes that the user still holds directly or
+// through another role aren't recorded, since the change doesn't affect them.
+func (m RoleModel) changeForUser(change func(context.Context, querier, int64, []string) error, actorID, userID int64, names []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = auditRoleChange(ctx, tx, actorID, userID, func() error {
+		return change(ctx, tx, userID, names)
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// auditRoleChange reads the effective permissions of a user before and after change,
+// and writes a grant entry for every code gained and a revoke entry for every code
+// lost.
+func auditRoleChange(ctx context.Context, q querier, actorID, userID int64, change func() error) error {
+	before, err := getPermissionsForUser(ctx, q, userID)
+	if err != nil {
+		return err
+	}
+	err = change()
+	if err != nil {
+		return err
+	}
+	after, err := getPermissionsForUser(ctx, q, userID)
+	if err != nil {
+		return err
+	}
+
+	err = auditPermissions(ctx, q, PermissionGranted, actorID, userID, difference(after, before))
+	if err != nil {
+		return err
+	}
+	return auditPermissions(ctx, q, PermissionRevoked, actorID, userID, difference(before, after))
+}
+
+// difference returns the codes in a which aren't in b.
+func difference(a, b Permissions) []string {
+	codes := []string{}
+	for _, code := range a {
+		if !validator.In(code, b...) {
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
+
+func addRolesForUser(ctx context.Context, q querier, userID int64, names []string) error {
+	query := `
+	INSERT INTO users_roles
+	SELECT $1, roles.id FROM roles WHERE roles.name = ANY($2)
+	ON CONFLICT DO NOTHING`
+	_, err := q.ExecContext(ctx, query, userID, pq.Array(names))
+	return err
+}
+
+func removeRolesForUser(ctx context.Context, q querier, userID int64, names []string) error {
+	query := `
+	DELETE FROM users_roles
+	USING roles
+	WHERE users_roles.role_id = roles.id
+	AND users_roles.user_id = $1
+	AND roles.name = ANY($2)`
+	_, err := q.ExecContext(ctx, query, userID, pq.Array(names))
+	return err
+}
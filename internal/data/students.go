@@ -24,19 +24,19 @@ type Student struct {
 }
 
 func ValidateStudent(v *validator.Validator, student *Student) {
-	v.Check(student.Name != "", "name", "must be provided")
-	v.Check(len(student.Name) <= 500, "name", "must not be more than 500 bytes long")
-	v.Check(student.Surname != "", "surname", "must be provided")
-	v.Check(len(student.Surname) <= 500, "surname", "must not be more than 500 bytes long")
-	v.Check(student.StudyYear != 0, "study_year", "must be provided")
-	v.Check(student.StudyYear >= 1, "study_year", "must be greater than 1")
-	v.Check(student.StudyYear <= 7, "study_year", "must be less than 7")
-	v.Check(student.Age != 0, "age", "must be provided")
-	v.Check(student.Age >= 11, "age", "must be greater than 11")
-	v.Check(student.Age <= 19, "age", "must be less than 19")
-	v.Check(student.FacultyId != 0, "faculty_id", "must be provided")
-	v.Check(student.Runtime != 0, "runtime", "must be provided")
-	v.Check(student.Runtime > 0, "runtime", "must be a positive integer")
+	v.CheckCode(student.Name != "", "name", validator.CodeRequired, "must be provided", nil)
+	v.CheckCode(len(student.Name) <= 500, "name", validator.CodeMaxLength, "must not be more than 500 bytes long", validator.Params{"max": 500})
+	v.CheckCode(student.Surname != "", "surname", validator.CodeRequired, "must be provided", nil)
+	v.CheckCode(len(student.Surname) <= 500, "surname", validator.CodeMaxLength, "must not be more than 500 bytes long", validator.Params{"max": 500})
+	v.CheckCode(student.StudyYear != 0, "study_year", validator.CodeRequired, "must be provided", nil)
+	v.CheckCode(student.StudyYear >= 1, "study_year", validator.CodeOutOfRange, "must be greater than 1", validator.Params{"min": 1})
+	v.CheckCode(student.StudyYear <= 7, "study_year", validator.CodeOutOfRange, "must be less than 7", validator.Params{"max": 7})
+	v.CheckCode(student.Age != 0, "age", validator.CodeRequired, "must be provided", nil)
+	v.CheckCode(student.Age >= 11, "age", validator.CodeOutOfRange, "must be greater than 11", validator.Params{"min": 11})
+	v.CheckCode(student.Age <= 19, "age", validator.CodeOutOfRange, "must be less than 19", validator.Params{"max": 19})
+	v.CheckCode(student.FacultyId != 0, "faculty_id", validator.CodeRequired, "must be provided", nil)
+	v.CheckCode(student.Runtime != 0, "runtime", validator.CodeRequired, "must be provided", nil)
+	v.CheckCode(student.Runtime > 0, "runtime", validator.CodeOutOfRange, "must be a positive integer", validator.Params{"min": 1})
 }
 
 // Define a StudentModel struct type which wraps a sql.DB connection pool.
@@ -99,14 +99,15 @@ func (s StudentModel) Get(id int64) (*Student, error) {
 	return &student, nil
 }
 
-// Add a placeholder method for updating a specific record in the student table.
+// Update a specific record in the students table, guarding against concurrent edits
+// by checking the version number in the same way as FacultyModel.Update().
 func (s StudentModel) Update(student *Student) error {
 	// Declare the SQL query for updating the record and returning the new version
 	// number.
 	query := `
 UPDATE students
 SET name = $1, surname=$2, study_year=$3, age=$4, faculty_id=$5, runtime = $6, version = version + 1
-WHERE id = $7
+WHERE id = $7 AND version = $8
 RETURNING version`
 	// Create an args slice containing the values for the placeholder parameters.
 	args := []interface{}{
@@ -117,10 +118,20 @@ RETURNING version`
 		student.FacultyId,
 		student.Runtime,
 		student.ID,
+		student.Version,
 	}
-	// Use the QueryRow() method to execute the query, passing in the args slice as a
-	// variadic parameter and scanning the new version value into the movie struct.
-	return s.DB.QueryRow(query, args...).Scan(&student.Version)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := s.DB.QueryRowContext(ctx, query, args...).Scan(&student.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
 }
 
 // Add a placeholder method for deleting a specific record from the student table.
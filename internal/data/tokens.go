@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"database/sql" // New import
 	"encoding/base32"
+	"encoding/hex"
 	"time"
 
 	"arnur.second.try/internal/validator"
@@ -16,6 +17,7 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
 )
 
 // Define a Token struct to hold the data for an individual token. This includes the
@@ -27,6 +29,19 @@ type Token struct {
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	UserAgent string    `json:"-"`
+}
+
+// A Session describes an active authentication token without exposing the token
+// itself. ID is a short hex prefix of the token hash, which is enough for a user to
+// tell their sessions apart.
+type Session struct {
+	ID         string     `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Expiry     time.Time  `json:"expiry"`
+	UserAgent  string     `json:"user_agent"`
+	Current    bool       `json:"current"`
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -67,8 +82,8 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 }
 
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
-	v.Check(tokenPlaintext != "", "token", "must be provided")
-	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
+	v.CheckCode(tokenPlaintext != "", "token", validator.CodeRequired, "must be provided", nil)
+	v.CheckCode(len(tokenPlaintext) == 26, "token", validator.CodeLength, "must be 26 bytes long", validator.Params{"length": 26})
 }
 
 // Define the TokenModel type.
@@ -87,14 +102,30 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, err
 }
 
+// The NewSession() method creates an authentication token which also records the
+// User-Agent of the client that logged in, so it can be shown in the list of sessions.
+func (m TokenModel) NewSession(userID int64, ttl time.Duration, userAgent string) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+	token.UserAgent = userAgent
+	err = m.Insert(token)
+	return token, err
+}
+
 func (m TokenModel) Insert(token *Token) error {
-	query := `
-		INSERT INTO tokens (hash, user_id, expiry, scope)
-		VALUES ($1, $2, $3, $4)`
-	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	_, err := m.DB.ExecContext(ctx, query, args...)
+	return insertToken(ctx, m.DB, token)
+}
+
+func insertToken(ctx context.Context, q querier, token *Token) error {
+	query := `
+		INSERT INTO tokens (hash, user_id, expiry, scope, user_agent)
+		VALUES ($1, $2, $3, $4, $5)`
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, token.UserAgent}
+	_, err := q.ExecContext(ctx, query, args...)
 	return err
 }
 
@@ -108,3 +139,65 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
+
+// The DeleteForPlaintext() method deletes a single token, given its plaintext value.
+func (m TokenModel) DeleteForPlaintext(scope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	query := `
+		DELETE FROM tokens
+		WHERE scope = $1 AND hash = $2`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, scope, tokenHash[:])
+	return err
+}
+
+// The Touch() method records that a token has just been used. To avoid a write on
+// every single request, last_used_at is only updated when it is more than a minute
+// old.
+func (m TokenModel) Touch(tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	query := `
+		UPDATE tokens
+		SET last_used_at = NOW()
+		WHERE hash = $1
+		AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, tokenHash[:])
+	return err
+}
+
+// The GetSessionsForUser() method returns the unexpired authentication tokens of a
+// user, newest first. The session matching currentPlaintext is flagged as current.
+func (m TokenModel) GetSessionsForUser(userID int64, currentPlaintext string) ([]*Session, error) {
+	currentHash := sha256.Sum256([]byte(currentPlaintext))
+	query := `
+		SELECT hash, created_at, last_used_at, expiry, user_agent
+		FROM tokens
+		WHERE user_id = $1 AND scope = $2 AND expiry > $3
+		ORDER BY created_at DESC`
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	rows, err := m.DB.QueryContext(ctx, query, userID, ScopeAuthentication, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions := []*Session{}
+	for rows.Next() {
+		var session Session
+		var hash []byte
+		err := rows.Scan(&hash, &session.CreatedAt, &session.LastUsedAt, &session.Expiry, &session.UserAgent)
+		if err != nil {
+			return nil, err
+		}
+		session.ID = hex.EncodeToString(hash[:8])
+		session.Current = string(hash) == string(currentHash[:])
+		sessions = append(sessions, &session)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"time"
 
+	"arnur.second.try/internal/i18n"
 	"arnur.second.try/internal/validator"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -20,6 +21,8 @@ type User struct {
 	Email     string    `json:"email"`
 	Password  password  `json:"-"`
 	Activated bool      `json:"activated"`
+	FacultyID *int64    `json:"faculty_id,omitempty"` // The user's own faculty, if any
+	Locale    string    `json:"locale,omitempty"`     // Preferred language, empty to follow Accept-Language
 	Version   int       `json:"-"`
 }
 
@@ -62,19 +65,23 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 }
 
 func ValidateEmail(v *validator.Validator, email string) {
-	v.Check(email != "", "email", "must be provided")
-	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
+	v.CheckCode(email != "", "email", validator.CodeRequired, "must be provided", nil)
+	v.CheckCode(validator.Matches(email, validator.EmailRX), "email", validator.CodeInvalidFormat, "must be a valid email address", nil)
 }
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
-	v.Check(password != "", "password", "must be provided")
-	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+	v.CheckCode(password != "", "password", validator.CodeRequired, "must be provided", nil)
+	v.CheckCode(len(password) >= 8, "password", validator.CodeMinLength, "must be at least 8 bytes long", validator.Params{"min": 8})
+	v.CheckCode(len(password) <= 72, "password", validator.CodeMaxLength, "must not be more than 72 bytes long", validator.Params{"max": 72})
+}
+func ValidateLocale(v *validator.Validator, locale string) {
+	v.CheckCode(locale == "" || i18n.Supported(locale), "locale", validator.CodeNotAllowed, "must be a supported locale", validator.Params{"allowed": i18n.Locales})
 }
 func ValidateUser(v *validator.Validator, user *User) {
-	v.Check(user.Name != "", "name", "must be provided")
-	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.CheckCode(user.Name != "", "name", validator.CodeRequired, "must be provided", nil)
+	v.CheckCode(len(user.Name) <= 500, "name", validator.CodeMaxLength, "must not be more than 500 bytes long", validator.Params{"max": 500})
 	// Call the standalone ValidateEmail() helper.
 	ValidateEmail(v, user.Email)
+	ValidateLocale(v, user.Locale)
 	// If the plaintext password is not nil, call the standalone
 	// ValidatePasswordPlaintext() helper.
 	if user.Password.plaintext != nil {
@@ -105,18 +112,22 @@ type UserModel struct {
 // RETURNING clause to read them into the User struct after the insert, in the same way
 // that we did when creating a movie.
 func (m UserModel) Insert(user *User) error {
-	query := `
-	INSERT INTO users (name, email, password_hash, activated)
-	VALUES ($1, $2, $3, $4)
-	RETURNING id, created_at, version`
-	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
+	return insertUser(ctx, m.DB, user)
+}
+
+func insertUser(ctx context.Context, q querier, user *User) error {
+	query := `
+	INSERT INTO users (name, email, password_hash, activated, faculty_id, locale)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at, version`
+	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated, user.FacultyID, user.Locale}
 	// If the table already contains a record with this email address, then when we try
 	// to perform the insert there will be a violation of the UNIQUE "users_email_key"
 	// constraint that we set up in the previous chapter. We check for this error
 	// specifically, and return custom ErrDuplicateEmail error instead.
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	err := q.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
@@ -128,12 +139,121 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
+// The Create() method inserts a new user, assigns the roles and grants the permission
+// codes in a single transaction, so that a failure part of the way through doesn't
+// leave a half-created user behind. The codes the user gets are recorded in
+// permissions_audit without an actor, as Create() is only used by hogwartsctl.
+func (m UserModel) Create(user *User, roles []string, codes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertUser(ctx, tx, user)
+	if err != nil {
+		return err
+	}
+	if len(roles) > 0 {
+		err = auditRoleChange(ctx, tx, 0, user.ID, func() error {
+			return addRolesForUser(ctx, tx, user.ID, roles)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if len(codes) > 0 {
+		err = grantPermissions(ctx, tx, 0, user.ID, codes)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// The Register() method signs up a new user. It inserts the user, gives them the named
+// roles and queues the welcome email, all in one transaction. The caller sets the
+// Template, Locale and RequestID of the email, and Register() fills in the recipient
+// and asks for an activation token, which the outbox worker creates when it delivers
+// the email. Either the user ends up with their email in the outbox, or nothing is
+// written at all, so a user can never be left without a way to activate their account.
+func (m UserModel) Register(user *User, roles []string, email *Email) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertUser(ctx, tx, user)
+	if err != nil {
+		return err
+	}
+	err = auditRoleChange(ctx, tx, 0, user.ID, func() error {
+		return addRolesForUser(ctx, tx, user.ID, roles)
+	})
+	if err != nil {
+		return err
+	}
+	email.Recipient = user.Email
+	email.UserID = &user.ID
+	email.TokenScope = ScopeActivation
+	email.Data = map[string]interface{}{
+		"userID": user.ID,
+	}
+	err = insertEmail(ctx, tx, email)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Retrieve the User details from the database based on the user's ID.
+func (m UserModel) Get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+SELECT id, created_at, name, email, password_hash, activated, faculty_id, locale, version
+FROM users
+WHERE id = $1`
+	var user User
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.FacultyID,
+		&user.Locale,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
 // Retrieve the User details from the database based on the user's email address.
 // Because we have a UNIQUE constraint on the email column, this SQL query will only
 // return one record (or none at all, in which case we return a ErrRecordNotFound error).
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-SELECT id, created_at, name, email, password_hash, activated, version
+SELECT id, created_at, name, email, password_hash, activated, faculty_id, locale, version
 FROM users
 WHERE email = $1`
 	var user User
@@ -146,6 +266,8 @@ WHERE email = $1`
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.FacultyID,
+		&user.Locale,
 		&user.Version,
 	)
 	if err != nil {
@@ -167,14 +289,16 @@ WHERE email = $1`
 func (m UserModel) Update(user *User) error {
 	query := `
 UPDATE users
-SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-WHERE id = $5 AND version = $6
+SET name = $1, email = $2, password_hash = $3, activated = $4, faculty_id = $5, locale = $6, version = version + 1
+WHERE id = $7 AND version = $8
 RETURNING version`
 	args := []interface{}{
 		user.Name,
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.FacultyID,
+		user.Locale,
 		user.ID,
 		user.Version,
 	}
@@ -200,7 +324,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 	// Set up the SQL query.
 	query := `
-	SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+	SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.faculty_id, users.locale, users.version
 	FROM users
 	INNER JOIN tokens
 	ON users.id = tokens.user_id
@@ -224,6 +348,8 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.FacultyID,
+		&user.Locale,
 		&user.Version,
 	)
 	if err != nil {
@@ -0,0 +1,113 @@
+// Package i18n picks the locale for a request and translates user-facing messages.
+//
+// Messages are looked up by their English text, so the code keeps using plain English
+// strings (which also makes English the fallback for free) and only the catalogs in
+// the catalogs directory need to know about other languages. A message may contain
+// fmt verbs, which are filled in after it has been translated.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Default is the locale used when the client doesn't ask for one we support.
+const Default = "en"
+
+//go:embed "catalogs"
+var catalogFS embed.FS
+
+// catalogs maps a locale to its translations, keyed by the English message. It is
+// loaded from the embedded JSON files when the package is initialized, so a broken
+// catalog stops the application straight away, in the same way as template.Must().
+var catalogs = mustLoadCatalogs()
+
+// Locales holds every supported locale, the default one first.
+var Locales = locales()
+
+func mustLoadCatalogs() map[string]map[string]string {
+	files, err := fs.Glob(catalogFS, "catalogs/*.json")
+	if err != nil {
+		panic(err)
+	}
+	catalogs := map[string]map[string]string{}
+	for _, file := range files {
+		js, err := catalogFS.ReadFile(file)
+		if err != nil {
+			panic(err)
+		}
+		var messages map[string]string
+		err = json.Unmarshal(js, &messages)
+		if err != nil {
+			panic(fmt.Sprintf("i18n: %s: %v", file, err))
+		}
+		catalogs[strings.TrimSuffix(path.Base(file), ".json")] = messages
+	}
+	return catalogs
+}
+
+func locales() []string {
+	locales := []string{Default}
+	for locale := range catalogs {
+		if locale != Default {
+			locales = append(locales, locale)
+		}
+	}
+	sort.Strings(locales[1:])
+	return locales
+}
+
+// Supported reports whether there is a catalog for the locale.
+func Supported(locale string) bool {
+	for _, l := range Locales {
+		if l == locale {
+			return true
+		}
+	}
+	return false
+}
+
+// Translate returns the message in the given locale, with any fmt verbs replaced by
+// args. Messages missing from the locale's catalog are returned in English.
+func Translate(locale, message string, args ...interface{}) string {
+	if translated, ok := catalogs[locale][message]; ok {
+		message = translated
+	}
+	if len(args) == 0 {
+		return message
+	}
+	return fmt.Sprintf(message, args...)
+}
+
+// Match picks the best supported locale for an Accept-Language header value such as
+// "ru-RU,ru;q=0.9,en;q=0.8". Only the primary language subtag is compared, so "kk-KZ"
+// matches "kk". If nothing matches, the Default locale is returned.
+func Match(acceptLanguage string) string {
+	best, bestQ := Default, 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		language, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if language == "*" {
+			language = Default
+		}
+		// Strictly greater, so that the first of several equally weighted languages wins.
+		if q > bestQ && Supported(language) {
+			best, bestQ = language, q
+		}
+	}
+	return best
+}
@@ -2,10 +2,13 @@ package jsonlog
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,81 +18,177 @@ type Level int8
 // Initialize constants which represent a specific severity level. We use the iota
 // keyword as a shortcut to assign successive integer values to the constants.
 const (
-	LevelInfo  Level = iota // Has the value 0.
-	LevelError              // Has the value 1.
-	LevelFatal              // Has the value 2.
-	LevelOff                // Has the value 3.
+	LevelDebug Level = iota // Has the value 0.
+	LevelInfo               // Has the value 1.
+	LevelWarn               // Has the value 2.
+	LevelError              // Has the value 3.
+	LevelFatal              // Has the value 4.
+	LevelOff                // Has the value 5.
 )
 
 // Return a human-friendly string for the severity level.
 func (l Level) String() string {
 	switch l {
+	case LevelDebug:
+		return "DEBUG"
 	case LevelInfo:
 		return "INFO"
+	case LevelWarn:
+		return "WARN"
 	case LevelError:
 		return "ERROR"
 	case LevelFatal:
 		return "FATAL"
+	case LevelOff:
+		return "OFF"
 	default:
 		return ""
 	}
 }
 
+// ParseLevel converts a level name such as "debug" or "WARN" back into a Level.
+func ParseLevel(s string) (Level, error) {
+	for l := LevelDebug; l <= LevelOff; l++ {
+		if strings.EqualFold(s, l.String()) {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("jsonlog: unknown level %q (expected debug|info|warn|error|fatal|off)", s)
+}
+
+// Properties holds the extra data for a log entry. Values can be of any type which
+// encodes to JSON, and time.Duration and error values are written in their readable
+// string form.
+type Properties map[string]interface{}
+
 // Define a custom Logger type. This holds the output destination that the log entries
 // will be written to, the minimum severity level that log entries will be written for,
-// plus a mutex for coordinating the writes.
+// whether stack traces are captured, how secrets are redacted, plus a mutex for
+// coordinating the writes. All of these are shared with the child loggers created by
+// With(), so changing the level of one changes it for all of them.
+//
+// The fields are the properties bound to this particular logger. They are added to
+// every entry it writes.
 type Logger struct {
 	out      io.Writer
-	minLevel Level
-	mu       sync.Mutex
+	minLevel *atomic.Int32
+	traces   *atomic.Bool
+	redact   *atomic.Pointer[redactor]
+	mu       *sync.Mutex
+	fields   Properties
 }
 
 // Return a new Logger instance which writes log entries at or above a minimum severity
-// level to a specific output destination.
+// level to a specific output destination. Stack traces are off until they are turned
+// on with SetStackTraces(), and the DefaultRedactedKeys are redacted.
 func New(out io.Writer, minLevel Level) *Logger {
-	return &Logger{
+	l := &Logger{
 		out:      out,
-		minLevel: minLevel,
+		minLevel: new(atomic.Int32),
+		traces:   new(atomic.Bool),
+		redact:   new(atomic.Pointer[redactor]),
+		mu:       new(sync.Mutex),
 	}
+	l.minLevel.Store(int32(minLevel))
+	l.redact.Store(newRedactor(DefaultRedactedKeys))
+	return l
+}
+
+// With returns a child logger which adds the given properties to every entry. The
+// child writes to the same destination, and shares the level and stack trace setting
+// with its parent.
+func (l *Logger) With(properties Properties) *Logger {
+	child := *l
+	child.fields = make(Properties, len(l.fields)+len(properties))
+	for key, value := range l.fields {
+		child.fields[key] = value
+	}
+	for key, value := range properties {
+		child.fields[key] = value
+	}
+	return &child
+}
+
+// Level returns the current minimum severity level.
+func (l *Logger) Level() Level {
+	return Level(l.minLevel.Load())
+}
+
+// SetLevel changes the minimum severity level. It is safe to call while other
+// goroutines are logging, so the level can be changed at runtime.
+func (l *Logger) SetLevel(level Level) {
+	l.minLevel.Store(int32(level))
+}
+
+// SetStackTraces controls whether a full debug.Stack() trace is captured for entries
+// at the ERROR and FATAL levels. Capturing a trace is relatively expensive, and the
+// traces make the log hard to read, so it is only worth doing when debugging.
+func (l *Logger) SetStackTraces(enabled bool) {
+	l.traces.Store(enabled)
+}
+
+// SetRedactedKeys replaces the list of property keys whose values are never logged.
+// Email addresses, tokens and credentials in URLs are scrubbed from every message and
+// string value regardless of the keys.
+func (l *Logger) SetRedactedKeys(keys []string) {
+	l.redact.Store(newRedactor(keys))
 }
 
 // Declare some helper methods for writing log entries at the different levels. Notice
 // that these all accept a map as the second parameter which can contain any arbitrary
 // 'properties' that you want to appear in the log entry.
-func (l *Logger) PrintInfo(message string, properties map[string]string) {
+func (l *Logger) PrintDebug(message string, properties Properties) {
+	l.print(LevelDebug, message, properties)
+}
+func (l *Logger) PrintInfo(message string, properties Properties) {
 	l.print(LevelInfo, message, properties)
 }
-func (l *Logger) PrintError(err error, properties map[string]string) {
+func (l *Logger) PrintWarn(message string, properties Properties) {
+	l.print(LevelWarn, message, properties)
+}
+func (l *Logger) PrintError(err error, properties Properties) {
 	l.print(LevelError, err.Error(), properties)
 }
-func (l *Logger) PrintFatal(err error, properties map[string]string) {
+func (l *Logger) PrintFatal(err error, properties Properties) {
 	l.print(LevelFatal, err.Error(), properties)
 	os.Exit(1) // For entries at the FATAL level, we also terminate the application.
 }
 
 // Print is an internal method for writing the log entry.
-func (l *Logger) print(level Level, message string, properties map[string]string) (int, error) {
+func (l *Logger) print(level Level, message string, properties Properties) (int, error) {
+	return l.output(level, time.Now(), message, properties)
+}
+
+// output writes a log entry with the given time. The slog Handler passes the time of
+// the record through here, and a zero time is left out of the entry altogether, which
+// is what slog expects of a handler.
+func (l *Logger) output(level Level, t time.Time, message string, properties Properties) (int, error) {
 	// If the severity level of the log entry is below the minimum severity for the
 	// logger, then return with no further action.
-	if level < l.minLevel {
+	if level < l.Level() {
 		return 0, nil
 
 	}
+	// Secrets must never reach the output, so the message and the properties are
+	// redacted before anything else is done with them.
+	redact := l.redact.Load()
 	// Declare an anonymous struct holding the data for the log entry.
 	aux := struct {
-		Level      string            `json:"level"`
-		Time       string            `json:"time"`
-		Message    string            `json:"message"`
-		Properties map[string]string `json:"properties,omitempty"`
-		Trace      string            `json:"trace,omitempty"`
+		Level      string     `json:"level"`
+		Time       string     `json:"time,omitempty"`
+		Message    string     `json:"message"`
+		Properties Properties `json:"properties,omitempty"`
+		Trace      string     `json:"trace,omitempty"`
 	}{
 		Level:      level.String(),
-		Time:       time.Now().UTC().Format(time.RFC3339),
-		Message:    message,
-		Properties: properties,
+		Message:    redact.scrub(message),
+		Properties: redact.properties(l.merge(properties)),
+	}
+	if !t.IsZero() {
+		aux.Time = t.UTC().Format(time.RFC3339)
 	}
-	// Include a stack trace for entries at the ERROR and FATAL levels.
-	if level >= LevelError {
+	// Include a stack trace for entries at the ERROR and FATAL levels, if enabled.
+	if level >= LevelError && l.traces.Load() {
 		aux.Trace = string(debug.Stack())
 	}
 	// Declare a line variable for holding the actual log entry text.
@@ -110,6 +209,28 @@ func (l *Logger) print(level Level, message string, properties map[string]string
 	return l.out.Write(append(line, '\n'))
 }
 
+// merge combines the bound fields with the properties of a single entry, which win if
+// the same key is used in both, and converts the values which don't encode nicely.
+func (l *Logger) merge(properties Properties) Properties {
+	if len(l.fields) == 0 && len(properties) == 0 {
+		return nil
+	}
+	merged := make(Properties, len(l.fields)+len(properties))
+	for _, props := range []Properties{l.fields, properties} {
+		for key, value := range props {
+			switch v := value.(type) {
+			case time.Duration:
+				merged[key] = v.String()
+			case error:
+				merged[key] = v.Error()
+			default:
+				merged[key] = v
+			}
+		}
+	}
+	return merged
+}
+
 // We also implement a Write() method on our Logger type so that it satisfies the
 // io.Writer interface. This writes a log entry at the ERROR level with no additional
 // properties.
@@ -0,0 +1,127 @@
+package jsonlog
+
+import (
+	"regexp"
+	"strings"
+)
+
+// DefaultRedactedKeys are the property keys whose values are never written to the log.
+// A key matches if it contains one of them, ignoring case, so "smtp_password" and
+// "Authorization" are both caught.
+var DefaultRedactedKeys = []string{"password", "token", "dsn", "authorization"}
+
+// Redacted replaces every value which is removed from a log entry.
+const Redacted = "[REDACTED]"
+
+var (
+	// The password in the userinfo part of a URL, such as a postgres:// DSN.
+	rxURLPassword = regexp.MustCompile(`(://[^:/@\s]*:)[^@\s]+@`)
+	// The credentials following a Bearer or Basic authorization scheme.
+	rxAuthScheme = regexp.MustCompile(`(?i)\b((?:bearer|basic)\s+)[^\s,;"]+`)
+	// Email addresses, including ones with the @ percent-encoded in a query string.
+	rxEmail = regexp.MustCompile(`[A-Za-z0-9._%+\-]+(?:@|%40)[A-Za-z0-9\-]+(?:\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`)
+	// Plaintext tokens, which are 16 random bytes encoded as 26 characters of base32.
+	rxToken = regexp.MustCompile(`\b[A-Z2-7]{26}\b`)
+)
+
+// redactor removes secrets from log entries. It drops the values of denylisted keys
+// entirely, and scrubs everything which looks like a credential, an email address or
+// a token out of the remaining strings, wherever it appears.
+type redactor struct {
+	keys []string
+	// rxKeyValue matches key=value pairs in strings, so that "password=pa55word" in a
+	// keyword DSN or "?token=..." in a URL are scrubbed even though they are not keys.
+	rxKeyValue *regexp.Regexp
+}
+
+func newRedactor(keys []string) *redactor {
+	r := &redactor{}
+	quoted := make([]string, 0, len(keys))
+	for _, key := range keys {
+		key = strings.ToLower(strings.TrimSpace(key))
+		if key == "" {
+			continue
+		}
+		r.keys = append(r.keys, key)
+		quoted = append(quoted, regexp.QuoteMeta(key))
+	}
+	if len(quoted) > 0 {
+		r.rxKeyValue = regexp.MustCompile(`(?i)([\w\-]*(?:` + strings.Join(quoted, "|") + `)[\w\-]*\s*=\s*)("[^"]*"|'[^']*'|[^\s&,;]+)`)
+	}
+	return r
+}
+
+// deniedKey reports whether the values of key must be dropped.
+func (r *redactor) deniedKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, denied := range r.keys {
+		if strings.Contains(key, denied) {
+			return true
+		}
+	}
+	return false
+}
+
+// scrub replaces the sensitive parts of s. The URL password comes first, because the
+// rest of a DSN would otherwise look like an email address.
+func (r *redactor) scrub(s string) string {
+	s = rxURLPassword.ReplaceAllString(s, "${1}"+Redacted+"@")
+	s = rxAuthScheme.ReplaceAllString(s, "${1}"+Redacted)
+	if r.rxKeyValue != nil {
+		s = r.rxKeyValue.ReplaceAllString(s, "${1}"+Redacted)
+	}
+	s = rxEmail.ReplaceAllString(s, Redacted)
+	return rxToken.ReplaceAllString(s, Redacted)
+}
+
+// properties returns a redacted copy of properties. Nested maps are redacted too, and
+// values which are not strings (numbers, booleans) are kept as they are.
+func (r *redactor) properties(properties Properties) Properties {
+	if properties == nil {
+		return nil
+	}
+	clean := make(Properties, len(properties))
+	for key, value := range properties {
+		if r.deniedKey(key) {
+			clean[key] = Redacted
+			continue
+		}
+		clean[key] = r.value(value)
+	}
+	return clean
+}
+
+func (r *redactor) value(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return r.scrub(v)
+	case []string:
+		clean := make([]string, len(v))
+		for i := range v {
+			clean[i] = r.scrub(v[i])
+		}
+		return clean
+	case Properties:
+		return r.properties(v)
+	case map[string]interface{}:
+		return map[string]interface{}(r.properties(v))
+	case map[string]string:
+		clean := make(map[string]string, len(v))
+		for key, s := range v {
+			if r.deniedKey(key) {
+				clean[key] = Redacted
+				continue
+			}
+			clean[key] = r.scrub(s)
+		}
+		return clean
+	case []interface{}:
+		clean := make([]interface{}, len(v))
+		for i := range v {
+			clean[i] = r.value(v[i])
+		}
+		return clean
+	default:
+		return v
+	}
+}
@@ -0,0 +1,163 @@
+package jsonlog
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Handler is a slog.Handler which writes through a Logger, so that packages using
+// log/slog produce exactly the same JSON entries as the rest of the application. The
+// attributes of a record become the properties of the entry, and groups become nested
+// objects inside the properties. A group only appears once something is added to it,
+// so a group without any attributes is left out of the entry.
+type Handler struct {
+	logger *Logger
+	// fields holds the attributes added with WithAttrs(), already nested inside their
+	// groups, and groups is the path of the group opened with WithGroup() (if any) that
+	// the attributes of a record are added to.
+	fields Properties
+	groups []string
+}
+
+// NewHandler returns a slog.Handler which writes to logger. The handler uses the
+// logger's minimum level, so changing it with SetLevel() also applies to slog.
+func NewHandler(logger *Logger) *Handler {
+	return &Handler{logger: logger}
+}
+
+// Map a slog level onto the nearest of our levels. slog allows any integer as a level,
+// so anything between two of the standard levels is rounded down to the lower one.
+// There is no slog equivalent of FATAL, as slog never exits the program.
+func levelFromSlog(level slog.Level) Level {
+	switch {
+	case level < slog.LevelInfo:
+		return LevelDebug
+	case level < slog.LevelWarn:
+		return LevelInfo
+	case level < slog.LevelError:
+		return LevelWarn
+	default:
+		return LevelError
+	}
+}
+
+func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
+	return levelFromSlog(level) >= h.logger.Level()
+}
+
+func (h *Handler) Handle(_ context.Context, record slog.Record) error {
+	attrs := Properties{}
+	record.Attrs(func(attr slog.Attr) bool {
+		addAttr(attrs, attr)
+		return true
+	})
+	properties := copyProperties(h.fields)
+	if len(attrs) > 0 {
+		mergeProperties(openGroups(properties, h.groups), attrs)
+	}
+	if len(properties) == 0 {
+		properties = nil
+	}
+	_, err := h.logger.output(levelFromSlog(record.Level), record.Time, record.Message, properties)
+	return err
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := Properties{}
+	for _, attr := range attrs {
+		addAttr(fields, attr)
+	}
+	if len(fields) == 0 {
+		return h
+	}
+	child := &Handler{logger: h.logger, fields: copyProperties(h.fields), groups: h.groups}
+	mergeProperties(openGroups(child.fields, child.groups), fields)
+	return child
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	groups := make([]string, len(h.groups), len(h.groups)+1)
+	copy(groups, h.groups)
+	return &Handler{logger: h.logger, fields: h.fields, groups: append(groups, name)}
+}
+
+// openGroups returns the map inside properties which the attributes for the given
+// group path belong in, creating the nested maps along the way.
+func openGroups(properties Properties, groups []string) Properties {
+	for _, name := range groups {
+		group, ok := properties[name].(Properties)
+		if !ok {
+			group = Properties{}
+			properties[name] = group
+		}
+		properties = group
+	}
+	return properties
+}
+
+// addAttr adds a single attribute to properties, following the rules of slog: empty
+// attributes are dropped, and a group with an empty key is inlined into its parent.
+func addAttr(properties Properties, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+	switch attr.Value.Kind() {
+	case slog.KindGroup:
+		group := Properties{}
+		for _, a := range attr.Value.Group() {
+			addAttr(group, a)
+		}
+		if len(group) == 0 {
+			return
+		}
+		if attr.Key == "" {
+			mergeProperties(properties, group)
+			return
+		}
+		mergeProperties(openGroups(properties, []string{attr.Key}), group)
+	case slog.KindDuration:
+		properties[attr.Key] = attr.Value.Duration().String()
+	case slog.KindTime:
+		properties[attr.Key] = attr.Value.Time().UTC().Format(time.RFC3339)
+	case slog.KindAny:
+		if err, ok := attr.Value.Any().(error); ok {
+			properties[attr.Key] = err.Error()
+			return
+		}
+		properties[attr.Key] = attr.Value.Any()
+	default:
+		properties[attr.Key] = attr.Value.Any()
+	}
+}
+
+// mergeProperties adds the properties in src to dst. Groups which exist in both are
+// merged rather than replaced.
+func mergeProperties(dst, src Properties) {
+	for key, value := range src {
+		if group, ok := value.(Properties); ok {
+			if existing, ok := dst[key].(Properties); ok {
+				mergeProperties(existing, group)
+				continue
+			}
+		}
+		dst[key] = value
+	}
+}
+
+// copyProperties makes a deep copy of the nested group maps, so that a child handler
+// never changes the fields of its parent.
+func copyProperties(properties Properties) Properties {
+	dup := make(Properties, len(properties))
+	for key, value := range properties {
+		if group, ok := value.(Properties); ok {
+			value = copyProperties(group)
+		}
+		dup[key] = value
+	}
+	return dup
+}
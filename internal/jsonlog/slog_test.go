@@ -0,0 +1,59 @@
+package jsonlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"testing/slogtest"
+)
+
+func TestHandlerConformance(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewHandler(New(&buf, LevelDebug))
+	// slogtest expects the attributes at the top level of each entry, next to the
+	// message, level and time under slog's own keys, so turn our format into that.
+	results := func() []map[string]any {
+		var ms []map[string]any
+		for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+			var entry struct {
+				Level      string         `json:"level"`
+				Time       *string        `json:"time"`
+				Message    string         `json:"message"`
+				Properties map[string]any `json:"properties"`
+			}
+			err := json.Unmarshal(line, &entry)
+			if err != nil {
+				t.Fatalf("invalid entry %q: %s", line, err)
+			}
+			m := entry.Properties
+			if m == nil {
+				m = map[string]any{}
+			}
+			m[slog.LevelKey] = entry.Level
+			m[slog.MessageKey] = entry.Message
+			if entry.Time != nil {
+				m[slog.TimeKey] = *entry.Time
+			}
+			ms = append(ms, m)
+		}
+		return ms
+	}
+	err := slogtest.TestHandler(h, results)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandlerEmptyGroup(t *testing.T) {
+	var buf bytes.Buffer
+	slog.New(NewHandler(New(&buf, LevelDebug))).WithGroup("g").Info("msg")
+	var entry map[string]any
+	err := json.Unmarshal(buf.Bytes(), &entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := entry["properties"]; ok {
+		t.Errorf("empty group was written: %s", buf.String())
+	}
+}
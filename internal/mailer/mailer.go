@@ -3,10 +3,13 @@ package mailer
 import (
 	"bytes"
 	"embed"
+	"fmt"
 	"html/template"
-	"time"
-
-	"github.com/go-mail/mail/v2"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
 )
 
 // Below we declare a new variable with the type embed.FS (embedded file system) to hold
@@ -18,75 +21,135 @@ import (
 //go:embed "templates"
 var templateFS embed.FS
 
-// Define a Mailer struct which contains a mail.Dialer instance (used to connect to a
-// SMTP server) and the sender information for your emails (the name and address you
-// want the email to be from, such as "Alice Smith <alice@example.com>").
+// Every template file must define these three named templates.
+var requiredBlocks = []string{"subject", "plainBody", "htmlBody"}
+
+// PreviewData is sample dynamic data which covers the values used by all of our
+// templates, so that any of them can be rendered for a preview.
+var PreviewData = map[string]interface{}{
+	"userID":             1,
+	"activationToken":    "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU",
+	"passwordResetToken": "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU",
+}
+
+// Define a Mailer struct which contains the Transport used to deliver the emails, the
+// sender information for your emails (the name and address you want the email to be
+// from, such as "Alice Smith <alice@example.com>") and the parsed templates, keyed by
+// their file name.
 type Mailer struct {
-	dialer *mail.Dialer
-	sender string
+	transport Transport
+	sender    string
+	templates map[string]*template.Template
 }
 
-func New(host string, port int, username, password, sender string) Mailer {
-	// Initialize a new mail.Dialer instance with the given SMTP server settings. We
-	// also configure this to use a 5-second timeout whenever we send an email.
-	dialer := mail.NewDialer(host, port, username, password)
-	dialer.Timeout = 5 * time.Second
-	// Return a Mailer instance containing the dialer and sender information.
+// New parses every template in the templates directory once, and returns an error if
+// any of them fails to parse, is missing one of the subject, plainBody and htmlBody
+// templates, or fails to render with PreviewData. That way a broken template
+// (including a translated one) stops the application at startup, instead of being
+// discovered when the first user tries to register. The resulting Mailer hands
+// the rendered messages to the given transport, e.g. NewSMTPTransport() in production
+// or NewMemoryTransport() in tests.
+func New(transport Transport, sender string) (Mailer, error) {
+	files, err := fs.Glob(templateFS, "templates/*.tmpl")
+	if err != nil {
+		return Mailer{}, err
+	}
+	templates := make(map[string]*template.Template, len(files))
+	for _, file := range files {
+		tmpl, err := template.New("email").ParseFS(templateFS, file)
+		if err != nil {
+			return Mailer{}, err
+		}
+		for _, block := range requiredBlocks {
+			if tmpl.Lookup(block) == nil {
+				return Mailer{}, fmt.Errorf("mailer: template %s does not define %q", file, block)
+			}
+			// A template can parse and still fail when it is executed, for example by
+			// calling a function with the wrong arguments, so render it once as well.
+			err = tmpl.ExecuteTemplate(io.Discard, block, PreviewData)
+			if err != nil {
+				return Mailer{}, fmt.Errorf("mailer: template %s: %w", file, err)
+			}
+		}
+		templates[path.Base(file)] = tmpl
+	}
 	return Mailer{
-		dialer: dialer,
-		sender: sender,
+		transport: transport,
+		sender:    sender,
+		templates: templates,
+	}, nil
+}
+
+// Templates returns the file names of all the available templates, in sorted order.
+func (m Mailer) Templates() []string {
+	names := make([]string, 0, len(m.templates))
+	for name := range m.templates {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
 }
 
 // Define a Send() method on the Mailer type. This takes the recipient email address
-// as the first parameter, the name of the file containing the templates, and any
-// dynamic data for the templates as an interface{} parameter.
-func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
-	// Use the ParseFS() method to parse the required template file from the embedded
-	// file system.
-	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+// as the first parameter, the name of the file containing the templates, the locale to
+// send the email in, and any dynamic data for the templates as an interface{}
+// parameter.
+func (m Mailer) Send(recipient, templateFile, locale string, data interface{}) error {
+	msg, err := m.Render(recipient, templateFile, locale, data)
 	if err != nil {
 		return err
 	}
+	return m.transport.Deliver(msg)
+}
+
+// Render executes the subject, plainBody and htmlBody templates of the given template
+// file and returns the resulting message without sending it.
+func (m Mailer) Render(recipient, templateFile, locale string, data interface{}) (*Message, error) {
+	tmpl, ok := m.lookup(templateFile, locale)
+	if !ok {
+		return nil, fmt.Errorf("mailer: unknown template %q", templateFile)
+	}
 	// Execute the named template "subject", passing in the dynamic data and storing the
 	// result in a bytes.Buffer variable.
 	subject := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(subject, "subject", data)
+	err := tmpl.ExecuteTemplate(subject, "subject", data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// Follow the same pattern to execute the "plainBody" template and store the result
 	// in the plainBody variable.
 	plainBody := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
-	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// And likewise with the "htmlBody" template.
 	htmlBody := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	// Use the mail.NewMessage() function to initialize a new mail.Message instance.
-	// Then we use the SetHeader() method to set the email recipient, sender and subject
-	// headers, the SetBody() method to set the plain-text body, and the AddAlternative()
-	// method to set the HTML body. It's important to note that AddAlternative() should
-	// always be called *after* SetBody().
-	msg := mail.NewMessage()
-	msg.SetHeader("To", recipient)
-	msg.SetHeader("From", m.sender)
-	msg.SetHeader("Subject", subject.String())
-	msg.SetBody("text/plain", plainBody.String())
-	msg.AddAlternative("text/html", htmlBody.String())
-	// Call the DialAndSend() method on the dialer, passing in the message to send. This
-	// opens a connection to the SMTP server, sends the message, then closes the
-	// connection. If there is a timeout, it will return a "dial tcp: i/o timeout"
-	// error.
-	err = m.dialer.DialAndSend(msg)
-	if err != nil {
-		return err
+	msg := &Message{
+		To:        recipient,
+		From:      m.sender,
+		Subject:   subject.String(),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+	}
+	return msg, nil
+}
+
+// lookup finds the translation of a template for the locale, which lives next to the
+// English template with the locale added before the extension (user_welcome.ru.tmpl
+// for user_welcome.tmpl). If there is no translation we fall back to the English
+// template itself.
+func (m Mailer) lookup(templateFile, locale string) (*template.Template, bool) {
+	if locale != "" {
+		localized := strings.TrimSuffix(templateFile, ".tmpl") + "." + locale + ".tmpl"
+		if tmpl, ok := m.templates[localized]; ok {
+			return tmpl, true
+		}
 	}
-	return nil
+	tmpl, ok := m.templates[templateFile]
+	return tmpl, ok
 }
@@ -0,0 +1,77 @@
+package mailer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSendThroughMemoryTransport(t *testing.T) {
+	transport := NewMemoryTransport()
+	m, err := New(transport, "Hogwarts <no-reply@hogwarts.net>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		recipient string
+		template  string
+		locale    string
+		subject   string
+	}{
+		{"english", "harry@hogwarts.net", "token_activation.tmpl", "", "Activate your Hogwarts account"},
+		{"russian", "ron@hogwarts.net", "token_activation.tmpl", "ru", "Активируйте учётную запись Хогвартса"},
+		{"fallback to english", "hermione@hogwarts.net", "token_activation.tmpl", "fr", "Activate your Hogwarts account"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport.Reset()
+			err := m.Send(tt.recipient, tt.template, tt.locale, map[string]interface{}{
+				"activationToken": "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			messages := transport.Messages()
+			if len(messages) != 1 {
+				t.Fatalf("got %d messages; want 1", len(messages))
+			}
+			msg := messages[0]
+			if msg.To != tt.recipient {
+				t.Errorf("got recipient %q; want %q", msg.To, tt.recipient)
+			}
+			if msg.From != "Hogwarts <no-reply@hogwarts.net>" {
+				t.Errorf("got sender %q", msg.From)
+			}
+			if msg.Subject != tt.subject {
+				t.Errorf("got subject %q; want %q", msg.Subject, tt.subject)
+			}
+			for body, content := range map[string]string{"plain": msg.PlainBody, "html": msg.HTMLBody} {
+				if !strings.Contains(content, `{"token": "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"}`) {
+					t.Errorf("%s body does not contain the token: %q", body, content)
+				}
+			}
+			if !strings.Contains(msg.HTMLBody, "<html>") {
+				t.Errorf("html body is not HTML: %q", msg.HTMLBody)
+			}
+		})
+	}
+}
+
+func TestSendUnknownTemplate(t *testing.T) {
+	transport := NewMemoryTransport()
+	m, err := New(transport, "Hogwarts <no-reply@hogwarts.net>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Send("harry@hogwarts.net", "no_such_template.tmpl", "", nil)
+	if err == nil {
+		t.Fatal("expected an error for an unknown template")
+	}
+	if n := len(transport.Messages()); n != 0 {
+		t.Errorf("got %d messages; want 0", n)
+	}
+}
@@ -0,0 +1,163 @@
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-mail/mail/v2"
+)
+
+// Message is a fully rendered email, ready to be handed to a Transport.
+type Message struct {
+	To        string
+	From      string
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+}
+
+// WriteTo writes the message to w in RFC 5322 format, with the plain-text body and
+// the HTML body as multipart/alternative parts. This is exactly what an SMTP server
+// would receive, so it's also what the file and stdout transports write out.
+func (msg *Message) WriteTo(w io.Writer) (int64, error) {
+	return msg.mail().WriteTo(w)
+}
+
+func (msg *Message) mail() *mail.Message {
+	// It's important to note that AddAlternative() should always be called *after*
+	// SetBody().
+	m := mail.NewMessage()
+	m.SetHeader("To", msg.To)
+	m.SetHeader("From", msg.From)
+	m.SetHeader("Subject", msg.Subject)
+	m.SetBody("text/plain", msg.PlainBody)
+	m.AddAlternative("text/html", msg.HTMLBody)
+	return m
+}
+
+// Transport is the interface implemented by everything which is able to deliver a
+// rendered Message. The Mailer takes care of executing the templates, so the
+// transports only need to worry about where the message ends up.
+type Transport interface {
+	Deliver(msg *Message) error
+}
+
+// SMTPTransport sends messages to a SMTP server.
+type SMTPTransport struct {
+	dialer *mail.Dialer
+}
+
+// NewSMTPTransport returns a transport which connects to the given SMTP server for
+// every message, using a 5-second timeout.
+func NewSMTPTransport(host string, port int, username, password string) *SMTPTransport {
+	dialer := mail.NewDialer(host, port, username, password)
+	dialer.Timeout = 5 * time.Second
+	return &SMTPTransport{dialer: dialer}
+}
+
+// Deliver opens a connection to the SMTP server, sends the message, then closes the
+// connection. If there is a timeout, it will return a "dial tcp: i/o timeout" error.
+func (t *SMTPTransport) Deliver(msg *Message) error {
+	return t.dialer.DialAndSend(msg.mail())
+}
+
+// FileTransport writes every message to its own .eml file in a maildir. Messages are
+// written to the tmp/ subdirectory first and then renamed into new/, so anything
+// reading the new/ directory never sees a half-written file.
+type FileTransport struct {
+	dir string
+	seq atomic.Uint64
+}
+
+// NewFileTransport creates the maildir layout under dir if it doesn't exist yet.
+func NewFileTransport(dir string) (*FileTransport, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		err := os.MkdirAll(filepath.Join(dir, sub), 0o700)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &FileTransport{dir: dir}, nil
+}
+
+func (t *FileTransport) Deliver(msg *Message) error {
+	name := fmt.Sprintf("%d.%d.eml", time.Now().UnixNano(), t.seq.Add(1))
+	tmp := filepath.Join(t.dir, "tmp", name)
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = msg.WriteTo(f)
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(t.dir, "new", name))
+}
+
+// WriterTransport writes every message to an io.Writer such as os.Stdout, separated
+// by a blank line. The mutex stops concurrent messages from being interleaved.
+type WriterTransport struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterTransport(w io.Writer) *WriterTransport {
+	return &WriterTransport{w: w}
+}
+
+func (t *WriterTransport) Deliver(msg *Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, err := msg.WriteTo(t.w)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(t.w, "\n\n")
+	return err
+}
+
+// MemoryTransport keeps every delivered message in memory, so tests and local tools
+// can assert on what would have been sent.
+type MemoryTransport struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{}
+}
+
+func (t *MemoryTransport) Deliver(msg *Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.messages = append(t.messages, *msg)
+	return nil
+}
+
+// Messages returns a copy of all the messages delivered so far, oldest first.
+func (t *MemoryTransport) Messages() []Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	messages := make([]Message, len(t.messages))
+	copy(messages, t.messages)
+	return messages
+}
+
+// Reset discards all the captured messages.
+func (t *MemoryTransport) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.messages = nil
+}
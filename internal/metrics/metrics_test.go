@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+
+	requests := r.NewCounter("requests_total", "Number of requests.", "method", "status")
+	requests.Inc("GET", "200")
+	requests.Inc("GET", "200")
+	requests.Add(3, "POST", "201")
+	requests.Add(-1, "POST", "201") // Ignored, counters never go down.
+
+	info := r.NewGauge("build_info", "Build information, with a \\ and a\nnewline.", "version")
+	info.Set(1, "v1 \"quoted\" C:\\path\nnext line")
+
+	duration := r.NewHistogram("request_duration_seconds", "Request durations.", []float64{0.125, 1}, "route")
+	duration.Observe(0.0625, "/v1/students")
+	duration.Observe(1, "/v1/students") // On the upper bound of a bucket, so counted in it.
+	duration.Observe(2, "/v1/students")
+
+	r.NewGaugeFunc("goroutines", "Number of goroutines.", func() float64 { return 7 })
+
+	want := `# HELP requests_total Number of requests.
+# TYPE requests_total counter
+requests_total{method="GET",status="200"} 2
+requests_total{method="POST",status="201"} 3
+# HELP build_info Build information, with a \\ and a\nnewline.
+# TYPE build_info gauge
+build_info{version="v1 \"quoted\" C:\\path\nnext line"} 1
+# HELP request_duration_seconds Request durations.
+# TYPE request_duration_seconds histogram
+request_duration_seconds_bucket{route="/v1/students",le="0.125"} 1
+request_duration_seconds_bucket{route="/v1/students",le="1"} 2
+request_duration_seconds_bucket{route="/v1/students",le="+Inf"} 3
+request_duration_seconds_sum{route="/v1/students"} 3.0625
+request_duration_seconds_count{route="/v1/students"} 3
+# HELP goroutines Number of goroutines.
+# TYPE goroutines gauge
+goroutines 7
+`
+
+	var buf bytes.Buffer
+	n, err := r.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("got n = %d; want %d", n, buf.Len())
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("hits_total", "Number of hits.").Inc()
+
+	rr := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if ct := rr.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("got Content-Type %q", ct)
+	}
+	want := "# HELP hits_total Number of hits.\n# TYPE hits_total counter\nhits_total 1\n"
+	if got := rr.Body.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWrongLabelCount(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("requests_total", "Number of requests.", "method")
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for a missing label value")
+		}
+	}()
+	c.Inc()
+}
@@ -0,0 +1,315 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// lockID is the key used with pg_advisory_lock(). Every instance of the application
+// uses the same key, so only one of them can run migrations at any given time.
+const lockID int64 = 7_340_592_781_001
+
+var (
+	ErrDirty    = errors.New("database is in a dirty state, fix it manually and then use force")
+	ErrNoChange = errors.New("no change")
+)
+
+// filenameRX matches migration file names such as 000001_create_faculties_table.up.sql.
+var filenameRX = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Define a Migration struct to hold the SQL for both directions of a single version.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+	hasUp   bool
+	hasDown bool
+}
+
+// Status describes the state of the schema_migrations table. Version is 0 when no
+// migration has been applied yet.
+type Status struct {
+	Version int64
+	Dirty   bool
+	Latest  int64
+}
+
+// Load reads all the migration files from the root of fsys. It returns an error if a
+// file name isn't in the expected format, if two files share the same version number,
+// if a version is missing one of its directions, or if there is a gap in the
+// sequence of versions.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		matches := filenameRX.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			// Anything which isn't an SQL file (such as the Go file doing the
+			// embedding) is simply ignored.
+			if strings.HasSuffix(entry.Name(), ".sql") {
+				return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+			}
+			continue
+		}
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration file name %q: %w", entry.Name(), err)
+		}
+		body, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, found := byVersion[version]
+		if !found {
+			migration = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != matches[2] {
+			return nil, fmt.Errorf("duplicate migration version %d: %q and %q", version, migration.Name, matches[2])
+		}
+
+		switch matches[3] {
+		case "up":
+			if migration.hasUp {
+				return nil, fmt.Errorf("duplicate up migration for version %d", version)
+			}
+			migration.Up, migration.hasUp = string(body), true
+		case "down":
+			if migration.hasDown {
+				return nil, fmt.Errorf("duplicate down migration for version %d", version)
+			}
+			migration.Down, migration.hasDown = string(body), true
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	// Versions must run 1, 2, 3... without any holes, and every version needs both
+	// an up and a down file.
+	for i, migration := range migrations {
+		if migration.Version != int64(i+1) {
+			return nil, fmt.Errorf("missing migration version %d", i+1)
+		}
+		if !migration.hasUp {
+			return nil, fmt.Errorf("missing up migration for version %d", migration.Version)
+		}
+		if !migration.hasDown {
+			return nil, fmt.Errorf("missing down migration for version %d", migration.Version)
+		}
+	}
+
+	return migrations, nil
+}
+
+// Define a Migrator type which applies a set of migrations to the database.
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration
+}
+
+// New loads the migrations from fsys and returns a Migrator for them.
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{DB: db, Migrations: migrations}, nil
+}
+
+// Latest returns the highest known migration version.
+func (m *Migrator) Latest() int64 {
+	return int64(len(m.Migrations))
+}
+
+// Up applies all of the pending migrations in order, returning how many were applied.
+func (m *Migrator) Up() (int, error) {
+	applied := 0
+	err := m.withLock(func(ctx context.Context, conn *sql.Conn) error {
+		version, dirty, err := readVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return ErrDirty
+		}
+		if version > m.Latest() {
+			return fmt.Errorf("database version %d is newer than the latest known migration %d", version, m.Latest())
+		}
+		for _, migration := range m.Migrations[version:] {
+			err := apply(ctx, conn, migration.Version, migration.Name, migration.Up, migration.Version)
+			if err != nil {
+				return err
+			}
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down rolls back the most recently applied migration, returning the version that
+// the database is now at. If no migrations have been applied it returns ErrNoChange.
+func (m *Migrator) Down() (int64, error) {
+	var current int64
+	err := m.withLock(func(ctx context.Context, conn *sql.Conn) error {
+		version, dirty, err := readVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return ErrDirty
+		}
+		if version == 0 {
+			return ErrNoChange
+		}
+		if version > m.Latest() {
+			return fmt.Errorf("database version %d is newer than the latest known migration %d", version, m.Latest())
+		}
+		migration := m.Migrations[version-1]
+		err = apply(ctx, conn, migration.Version, migration.Name, migration.Down, version-1)
+		if err != nil {
+			return err
+		}
+		current = version - 1
+		return nil
+	})
+	return current, err
+}
+
+// Status returns the current schema version along with the latest known version.
+func (m *Migrator) Status() (Status, error) {
+	status := Status{Latest: m.Latest()}
+	err := m.withLock(func(ctx context.Context, conn *sql.Conn) error {
+		var err error
+		status.Version, status.Dirty, err = readVersion(ctx, conn)
+		return err
+	})
+	return status, err
+}
+
+// Force sets the schema version without running any migrations and clears the dirty
+// flag. It is intended for recovering from a failed migration once the database has
+// been fixed by hand.
+func (m *Migrator) Force(version int64) error {
+	if version < 0 || version > m.Latest() {
+		return fmt.Errorf("version must be between 0 and %d", m.Latest())
+	}
+	return m.withLock(func(ctx context.Context, conn *sql.Conn) error {
+		return setVersion(ctx, conn, version, false)
+	})
+}
+
+// withLock runs fn on a dedicated connection while holding a session-level advisory
+// lock, so that several replicas starting at the same time don't race each other. It
+// also makes sure that the schema_migrations table exists.
+func (m *Migrator) withLock(fn func(ctx context.Context, conn *sql.Conn) error) error {
+	ctx := context.Background()
+
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID)
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, lockID)
+
+	query := `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint NOT NULL PRIMARY KEY,
+		dirty boolean NOT NULL
+	)`
+	_, err = conn.ExecContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	return fn(ctx, conn)
+}
+
+// apply runs the SQL for a single migration and records the resulting version in the
+// same transaction. If the migration fails, the version is recorded as dirty so that
+// nothing else is run until somebody has looked at it.
+func apply(ctx context.Context, conn *sql.Conn, version int64, name, body string, target int64) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, body)
+	if err == nil {
+		err = setVersion(ctx, tx, target, false)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		tx.Rollback()
+		if dirtyErr := setVersion(ctx, conn, version, true); dirtyErr != nil {
+			return fmt.Errorf("migration %d_%s failed: %w (and marking it dirty failed: %v)", version, name, err, dirtyErr)
+		}
+		return fmt.Errorf("migration %d_%s failed: %w", version, name, err)
+	}
+
+	return nil
+}
+
+// execer is satisfied by both *sql.Conn and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// The schema_migrations table only ever holds a single row, containing the version of
+// the last applied migration. This is the same layout as golang-migrate uses, so
+// databases that were migrated with that tool are picked up as they are.
+func readVersion(ctx context.Context, conn *sql.Conn) (int64, bool, error) {
+	var version int64
+	var dirty bool
+	err := conn.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, false, nil
+		default:
+			return 0, false, err
+		}
+	}
+	return version, dirty, nil
+}
+
+func setVersion(ctx context.Context, db execer, version int64, dirty bool) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+	if version == 0 && !dirty {
+		return nil
+	}
+	_, err = db.ExecContext(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, $2)`, version, dirty)
+	return err
+}
@@ -13,14 +13,43 @@ var (
 	EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 )
 
-// Define a new Validator type which contains a map of validation errors.
+// The codes describing why a validation check failed. Unlike the messages, which are
+// meant for people (and get translated), the codes are stable and safe for clients to
+// switch on.
+const (
+	CodeRequired      = "required"
+	CodeMinLength     = "min_length"
+	CodeMaxLength     = "max_length"
+	CodeLength        = "length"
+	CodeOutOfRange    = "out_of_range"
+	CodeInvalidFormat = "invalid_format"
+	CodeNotAllowed    = "not_allowed"
+	CodeDuplicate     = "duplicate"
+	CodeAlreadyExists = "already_exists"
+	CodeInvalid       = "invalid"
+)
+
+// Params holds the parameters of a failed check, such as {"max": 500} for max_length.
+type Params map[string]interface{}
+
+// Error describes a single failed validation check.
+type Error struct {
+	Code    string `json:"code"`
+	Params  Params `json:"params,omitempty"`
+	Message string `json:"message"`
+}
+
+// Define a new Validator type which contains a map of validation errors. Errors keeps
+// only the first message for each key, which is what the original error format
+// returns. Details keeps every failed check for each key along with its code.
 type Validator struct {
-	Errors map[string]string
+	Errors  map[string]string
+	Details map[string][]Error
 }
 
-// New is a helper which creates a new Validator instance with an empty errors map.
+// New is a helper which creates a new Validator instance with empty errors maps.
 func New() *Validator {
-	return &Validator{Errors: make(map[string]string)}
+	return &Validator{Errors: make(map[string]string), Details: make(map[string][]Error)}
 }
 
 // Valid returns true if the errors map doesn't contain any entries.
@@ -28,9 +57,15 @@ func (v *Validator) Valid() bool {
 	return len(v.Errors) == 0
 }
 
-// AddError adds an error message to the map (so long as no entry already exists for
-// the given key).
+// AddError adds an error message with the generic "invalid" code.
 func (v *Validator) AddError(key, message string) {
+	v.AddCodedError(key, CodeInvalid, message, nil)
+}
+
+// AddCodedError records a failed check for the given key. Every failure is kept in
+// Details, but only the first message for a key makes it into Errors.
+func (v *Validator) AddCodedError(key, code, message string, params Params) {
+	v.Details[key] = append(v.Details[key], Error{Code: code, Params: params, Message: message})
 	if _, exists := v.Errors[key]; !exists {
 		v.Errors[key] = message
 	}
@@ -43,6 +78,14 @@ func (v *Validator) Check(ok bool, key, message string) {
 	}
 }
 
+// CheckCode is like Check, but records a machine-readable code and its parameters
+// along with the message.
+func (v *Validator) CheckCode(ok bool, key, code, message string, params Params) {
+	if !ok {
+		v.AddCodedError(key, code, message, params)
+	}
+}
+
 // In returns true if a specific value is in a list of strings.
 func In(value string, list ...string) bool {
 	for i := range list {
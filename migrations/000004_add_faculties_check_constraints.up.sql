@@ -1,2 +1,2 @@
-ALTER TABLE faculties  DROP CONSTRAINT IF EXISTS faculties_runtime_check;
-ALTER TABLE faculties DROP CONSTRAINT IF EXISTS faculties_year_check;
\ No newline at end of file
+ALTER TABLE faculties ADD CONSTRAINT faculties_runtime_check CHECK (runtime >= 0);
+ALTER TABLE faculties ADD CONSTRAINT faculties_year_check CHECK (year BETWEEN 0 AND date_part('year', now()));
@@ -1,2 +0,0 @@
-DROP TABLE IF EXISTS users_permissions;
-DROP TABLE IF EXISTS permissions;
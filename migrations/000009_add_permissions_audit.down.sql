@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS permissions_audit;
+DELETE FROM permissions WHERE code = 'users:admin';
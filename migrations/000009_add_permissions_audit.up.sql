@@ -0,0 +1,11 @@
+INSERT INTO permissions (code)
+VALUES ('users:admin');
+
+CREATE TABLE IF NOT EXISTS permissions_audit (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    actor_id bigint REFERENCES users ON DELETE SET NULL,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    permission_code text NOT NULL,
+    action text NOT NULL
+);
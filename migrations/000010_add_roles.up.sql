@@ -0,0 +1,37 @@
+CREATE TABLE IF NOT EXISTS roles (
+    id bigserial PRIMARY KEY,
+    name text UNIQUE NOT NULL
+);
+CREATE TABLE IF NOT EXISTS roles_permissions (
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+CREATE TABLE IF NOT EXISTS users_roles (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    PRIMARY KEY (user_id, role_id)
+);
+-- Add the wildcard codes. "students:*" grants every students permission and "*"
+-- grants everything.
+INSERT INTO permissions (code)
+VALUES
+('students:*'),
+('faculties:*'),
+('users:*'),
+('*');
+
+INSERT INTO roles (name)
+VALUES
+('student'),
+('prefect'),
+('head_of_house'),
+('headmaster');
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE (roles.name = 'student' AND permissions.code IN ('students:read', 'faculties:read'))
+OR (roles.name = 'prefect' AND permissions.code IN ('students:read', 'students:write', 'faculties:read'))
+OR (roles.name = 'head_of_house' AND permissions.code IN ('students:*', 'faculties:*'))
+OR (roles.name = 'headmaster' AND permissions.code = '*');
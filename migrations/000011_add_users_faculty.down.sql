@@ -0,0 +1,13 @@
+DELETE FROM roles_permissions
+USING roles
+WHERE roles_permissions.role_id = roles.id AND roles.name = 'head_of_house';
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE roles.name = 'head_of_house'
+AND permissions.code IN ('students:*', 'faculties:*');
+
+DELETE FROM permissions WHERE code IN ('students:read:own_faculty', 'students:write:own_faculty');
+
+ALTER TABLE users DROP COLUMN IF EXISTS faculty_id;
@@ -0,0 +1,18 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS faculty_id bigint REFERENCES faculties ON DELETE SET NULL;
+
+-- Scoped codes only apply to students whose faculty_id matches the user's own.
+INSERT INTO permissions (code)
+VALUES
+('students:read:own_faculty'),
+('students:write:own_faculty');
+
+-- Heads of house may only see and edit the students of their own house.
+DELETE FROM roles_permissions
+USING roles
+WHERE roles_permissions.role_id = roles.id AND roles.name = 'head_of_house';
+
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE roles.name = 'head_of_house'
+AND permissions.code IN ('students:read:own_faculty', 'students:write:own_faculty', 'faculties:read');
@@ -0,0 +1,5 @@
+INSERT INTO roles_permissions
+SELECT roles.id, permissions.id
+FROM roles, permissions
+WHERE roles.name = 'student' AND permissions.code = 'faculties:read'
+ON CONFLICT DO NOTHING;
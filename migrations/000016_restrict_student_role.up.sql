@@ -0,0 +1,8 @@
+-- New users only had students:read before roles were added, so the student role they
+-- now get at registration must not grant anything more than that.
+DELETE FROM roles_permissions
+USING roles, permissions
+WHERE roles_permissions.role_id = roles.id
+AND roles_permissions.permission_id = permissions.id
+AND roles.name = 'student'
+AND permissions.code = 'faculties:read';
@@ -0,0 +1,2 @@
+ALTER TABLE email_outbox DROP COLUMN IF EXISTS token_scope;
+ALTER TABLE email_outbox DROP COLUMN IF EXISTS user_id;
@@ -0,0 +1,15 @@
+-- Plaintext tokens are no longer kept in the outbox. An email records the user and the
+-- scope of the token that it needs instead, and the token is created when the email is
+-- delivered.
+ALTER TABLE email_outbox ADD COLUMN IF NOT EXISTS user_id bigint REFERENCES users ON DELETE CASCADE;
+ALTER TABLE email_outbox ADD COLUMN IF NOT EXISTS token_scope text NOT NULL DEFAULT '';
+
+UPDATE email_outbox
+SET user_id = users.id
+FROM users
+WHERE users.email = email_outbox.recipient;
+
+UPDATE email_outbox
+SET token_scope = CASE WHEN data ? 'activationToken' THEN 'activation' ELSE 'password-reset' END,
+    data = data - 'activationToken' - 'passwordResetToken'
+WHERE data ?| ARRAY['activationToken', 'passwordResetToken'];
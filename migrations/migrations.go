@@ -0,0 +1,11 @@
+// Package migrations embeds the SQL migration files into the binary, so that the API
+// can apply them itself without relying on an external tool or on the files being
+// present on disk.
+package migrations
+
+import "embed"
+
+// FS holds every NNNNNN_name.up.sql and NNNNNN_name.down.sql file in this directory.
+//
+//go:embed *.sql
+var FS embed.FS